@@ -0,0 +1,386 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// invalidator is implemented by token providers and credentials providers that
+// cache state which can be discarded after the server rejects authentication.
+// *TokenCache and *aws.CredentialsCache implement it.
+type invalidator interface {
+	Invalidate()
+}
+
+// invalidateTokenProvider discards any cached tokens and credentials held by p.
+func invalidateTokenProvider(p TokenProvider) {
+	if inv, ok := p.(invalidator); ok {
+		inv.Invalidate()
+	}
+}
+
+// pgxModule is the module path of pgx, whose version gates the in-connection
+// auth retry.
+const pgxModule = "github.com/jackc/pgx/v5"
+
+// supportedPgxVersion is the pgx minor version whose connection handshake the
+// in-connection auth retry relies on, as checked by TestPgconnHandshakeContract.
+// Raise it only after that test passes against the new version.
+const supportedPgxVersion = "v5.8"
+
+// inConnectionRetrySupported reports whether the pgx in the build is a version
+// that the in-connection auth retry was verified against. With other versions
+// pools only discard cached tokens after a rejection, and the next connection
+// attempt signs a fresh one.
+var inConnectionRetrySupported = sync.OnceValue(func() bool {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return false
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != pgxModule {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		return pgxVersionSupported(dep.Version)
+	}
+	return false
+})
+
+// pgxVersionSupported reports whether version is a release of supportedPgxVersion.
+func pgxVersionSupported(version string) bool {
+	return strings.HasPrefix(version, supportedPgxVersion+".")
+}
+
+// PostgreSQL backend message types inspected during the startup handshake.
+const (
+	msgTypeAuthentication = 'R'
+	msgTypeErrorResponse  = 'E'
+)
+
+// maxStartupSniffBytes bounds the bytes buffered while looking for the outcome
+// of authentication. The messages of interest are far smaller than this.
+const maxStartupSniffBytes = 16 * 1024
+
+// authFailureConn wraps the connection used for the PostgreSQL startup handshake
+// and calls onAuthFailure if the server rejects authentication. Until the
+// outcome is known, complete backend messages are passed through unchanged.
+//
+// pgxpool has no hook around a connection attempt, so retrying a rejected
+// attempt has to happen inside it. This depends on details of pgconn's
+// handshake: the hook's config is the one the password is later read from,
+// the startup message is the first thing written, TLS is negotiated with an
+// SSLRequest and a single TLS config, and a read error is returned as the
+// connect error after a cancel request is dialed. These are checked by
+// TestPgconnHandshakeContract, and the retry is only enabled for the pgx
+// version checked, see inConnectionRetrySupported.
+//
+// While retries remain, a rejection is not passed on: the connection is
+// replaced by a new one on which the recorded startup message is replayed, with
+// config.Password set to a fresh token. pgconn answers the new server's
+// authentication request with it, unaware of the retry. Inspection stops once
+// the outcome is known. A final rejection is returned as a read error built
+// by authError, so that pgconn reports it like any other connection error.
+type authFailureConn struct {
+	ctx    context.Context
+	config *pgconn.Config
+	next   func(context.Context, *pgconn.Config, net.Conn) (net.Conn, error)
+
+	onAuthFailure func()
+	newToken      func(context.Context) (string, error)
+	authError     func(error) error
+	retries       int
+
+	mu   sync.Mutex // guards conn, which is replaced on retry
+	conn net.Conn
+
+	startup []byte // bytes written by the client: the startup message first
+	buf     []byte // received bytes not yet parsed into complete messages
+	ready   []byte // complete messages not yet returned
+	readErr error
+	done    bool
+}
+
+// newAuthFailureDetector returns an AfterNetConnect hook that watches the startup
+// handshake for authentication rejections, since pgxpool offers no hook that
+// sees the connection error. onAuthFailure, if set, is called on each
+// rejection, and up to retries times the attempt is repeated on a new
+// connection authenticated with a token from newToken. If authError is set, the
+// final rejection is reported as authError applied to the server's
+// *pgconn.PgError.
+func newAuthFailureDetector(
+	next func(context.Context, *pgconn.Config, net.Conn) (net.Conn, error),
+	onAuthFailure func(),
+	retries int,
+	newToken func(context.Context) (string, error),
+	authError func(error) error,
+) func(context.Context, *pgconn.Config, net.Conn) (net.Conn, error) {
+	return func(ctx context.Context, cfg *pgconn.Config, conn net.Conn) (net.Conn, error) {
+		if next != nil {
+			var err error
+			conn, err = next(ctx, cfg, conn)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &authFailureConn{
+			ctx:           ctx,
+			config:        cfg,
+			next:          next,
+			onAuthFailure: onAuthFailure,
+			newToken:      newToken,
+			authError:     authError,
+			retries:       retries,
+			conn:          conn,
+		}, nil
+	}
+}
+
+func (c *authFailureConn) current() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *authFailureConn) Read(p []byte) (int, error) {
+	for len(c.ready) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if c.done {
+			return c.current().Read(p)
+		}
+		chunk := make([]byte, 4096)
+		n, err := c.current().Read(chunk)
+		c.readErr = err
+		if n > 0 {
+			c.inspect(chunk[:n])
+		}
+	}
+	n := copy(p, c.ready)
+	c.ready = c.ready[n:]
+	return n, nil
+}
+
+func (c *authFailureConn) Write(p []byte) (int, error) {
+	if !c.done && len(c.startup)+len(p) <= maxStartupSniffBytes {
+		c.startup = append(c.startup, p...)
+	}
+	return c.current().Write(p)
+}
+
+func (c *authFailureConn) Close() error                       { return c.current().Close() }
+func (c *authFailureConn) LocalAddr() net.Addr                { return c.current().LocalAddr() }
+func (c *authFailureConn) RemoteAddr() net.Addr               { return c.current().RemoteAddr() }
+func (c *authFailureConn) SetDeadline(t time.Time) error      { return c.current().SetDeadline(t) }
+func (c *authFailureConn) SetReadDeadline(t time.Time) error  { return c.current().SetReadDeadline(t) }
+func (c *authFailureConn) SetWriteDeadline(t time.Time) error { return c.current().SetWriteDeadline(t) }
+
+// inspect parses backend messages until AuthenticationOk or an ErrorResponse is
+// seen, moving complete messages to c.ready.
+func (c *authFailureConn) inspect(data []byte) {
+	c.buf = append(c.buf, data...)
+	for !c.done {
+		if len(c.buf) < 5 {
+			break
+		}
+		msgType := c.buf[0]
+		msgLen := int(binary.BigEndian.Uint32(c.buf[1:5]))
+		if msgLen < 4 || msgLen+1 > maxStartupSniffBytes {
+			c.finish()
+			return
+		}
+		if len(c.buf) < msgLen+1 {
+			break
+		}
+		msg, body := c.buf[:msgLen+1], c.buf[5:msgLen+1]
+
+		switch msgType {
+		case msgTypeAuthentication:
+			if len(body) >= 4 && binary.BigEndian.Uint32(body[:4]) == 0 {
+				c.finish() // AuthenticationOk
+				return
+			}
+		case msgTypeErrorResponse:
+			code := errorResponseCode(body)
+			if code == ErrorCodeInvalidAuthorization || code == ErrorCodeInvalidPassword {
+				if c.onAuthFailure != nil {
+					c.onAuthFailure()
+				}
+				if c.retry() {
+					return
+				}
+				if c.reject(body) {
+					return
+				}
+			}
+			c.finish()
+			return
+		}
+		c.ready = append(c.ready, msg...)
+		c.buf = c.buf[msgLen+1:]
+	}
+	if len(c.buf) > maxStartupSniffBytes {
+		c.finish()
+	}
+}
+
+// finish stops inspection, releasing buffered bytes to the reader.
+func (c *authFailureConn) finish() {
+	c.done = true
+	c.ready = append(c.ready, c.buf...)
+	c.buf = nil
+	c.startup = nil
+}
+
+// reject stops inspection, replacing the ErrorResponse with body and anything
+// after it by the error built by c.authError. It reports whether it did so.
+func (c *authFailureConn) reject(body []byte) bool {
+	if c.authError == nil {
+		return false
+	}
+	var msg pgproto3.ErrorResponse
+	if err := msg.Decode(body); err != nil {
+		return false
+	}
+	c.done = true
+	c.buf = nil
+	c.startup = nil
+	c.readErr = c.authError(pgconn.ErrorResponseToPgError(&msg))
+
+	// pgconn closes a connection that fails with a read error by dialing the
+	// server again to send a cancel request. There is nothing to cancel, so
+	// refuse that dial; config belongs to this connection attempt only.
+	c.config.DialFunc = func(context.Context, string, string) (net.Conn, error) {
+		return nil, errors.New("connection rejected by server")
+	}
+	return true
+}
+
+// retry replaces the rejected connection with a new one on which the startup
+// message has been sent, and reports whether it succeeded. If not, the
+// rejection is passed on.
+func (c *authFailureConn) retry() bool {
+	if c.retries <= 0 || c.newToken == nil || len(c.startup) < 4 {
+		return false
+	}
+	startupLen := int(binary.BigEndian.Uint32(c.startup[:4]))
+	if startupLen < 8 || startupLen > len(c.startup) {
+		return false
+	}
+	c.retries--
+
+	token, err := c.newToken(c.ctx)
+	if err != nil {
+		return false
+	}
+	conn, err := c.reconnect()
+	if err != nil {
+		return false
+	}
+	if _, err := conn.Write(c.startup[:startupLen]); err != nil {
+		conn.Close()
+		return false
+	}
+
+	c.mu.Lock()
+	old := c.conn
+	c.conn = conn
+	c.mu.Unlock()
+	old.Close()
+
+	c.config.Password = token
+	c.startup = c.startup[:startupLen]
+	c.buf = nil
+	c.readErr = nil
+	return true
+}
+
+// reconnect dials the server again and negotiates TLS as pgconn does. The
+// connector clears Fallbacks, so config.TLSConfig is the only TLS config.
+func (c *authFailureConn) reconnect() (net.Conn, error) {
+	network, address := pgconn.NetworkAddress(c.config.Host, c.config.Port)
+	conn, err := c.config.DialFunc(c.ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.config.TLSConfig != nil {
+		if c.config.SSLNegotiation != "direct" {
+			if err := requestTLS(conn); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		tlsConn := tls.Client(conn, c.config.TLSConfig)
+		if err := tlsConn.HandshakeContext(c.ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	if c.next != nil {
+		next, err := c.next(c.ctx, c.config, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = next
+	}
+	return conn, nil
+}
+
+// requestTLS sends an SSLRequest and checks that the server accepts it.
+func requestTLS(conn net.Conn) error {
+	request, err := (&pgproto3.SSLRequest{}).Encode(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	response := make([]byte, 1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return err
+	}
+	if response[0] != 'S' {
+		return errors.New("server refused TLS connection")
+	}
+	return nil
+}
+
+// errorResponseCode returns the SQLSTATE field of an ErrorResponse message body.
+func errorResponseCode(body []byte) string {
+	for len(body) > 0 && body[0] != 0 {
+		fieldType := body[0]
+		end := bytes.IndexByte(body[1:], 0)
+		if end < 0 {
+			return ""
+		}
+		if fieldType == 'C' {
+			return string(body[1 : end+1])
+		}
+		body = body[end+2:]
+	}
+	return ""
+}
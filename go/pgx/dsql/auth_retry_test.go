@@ -0,0 +1,359 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeBackend encodes backend messages into their wire format.
+func encodeBackend(t *testing.T, msgs ...pgproto3.BackendMessage) []byte {
+	t.Helper()
+	var buf []byte
+	for _, msg := range msgs {
+		var err error
+		buf, err = msg.Encode(buf)
+		require.NoError(t, err)
+	}
+	return buf
+}
+
+// readThroughDetector writes data from the server side of a pipe and reads it
+// through the auth failure detector in small chunks, returning how many times
+// an auth failure was reported.
+func readThroughDetector(t *testing.T, data []byte) int32 {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+
+	var failures atomic.Int32
+	hook := newAuthFailureDetector(nil, func() { failures.Add(1) }, 0, nil, nil)
+	conn, err := hook(context.Background(), nil, client)
+	require.NoError(t, err)
+
+	go func() {
+		defer server.Close()
+		_, _ = server.Write(data)
+	}()
+
+	received, err := io.ReadAll(readerFunc(func(p []byte) (int, error) {
+		// Read in small chunks to exercise message reassembly.
+		if len(p) > 3 {
+			p = p[:3]
+		}
+		return conn.Read(p)
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+	return failures.Load()
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestAuthFailureDetectorReportsRejection(t *testing.T) {
+	for _, code := range []string{ErrorCodeInvalidPassword, ErrorCodeInvalidAuthorization} {
+		t.Run(code, func(t *testing.T) {
+			data := encodeBackend(t,
+				&pgproto3.AuthenticationCleartextPassword{},
+				&pgproto3.ErrorResponse{Severity: "FATAL", Code: code, Message: "access denied"},
+			)
+			assert.Equal(t, int32(1), readThroughDetector(t, data))
+		})
+	}
+}
+
+func TestAuthFailureDetectorIgnoresSuccessAndOtherErrors(t *testing.T) {
+	success := encodeBackend(t,
+		&pgproto3.AuthenticationCleartextPassword{},
+		&pgproto3.AuthenticationOk{},
+		// Errors after authentication are not auth failures.
+		&pgproto3.ErrorResponse{Severity: "ERROR", Code: ErrorCodeInvalidPassword, Message: "ignored"},
+	)
+	assert.Equal(t, int32(0), readThroughDetector(t, success))
+
+	otherError := encodeBackend(t,
+		&pgproto3.ErrorResponse{Severity: "FATAL", Code: "3D000", Message: "database does not exist"},
+	)
+	assert.Equal(t, int32(0), readThroughDetector(t, otherError))
+}
+
+func TestErrorResponseCode(t *testing.T) {
+	data := encodeBackend(t, &pgproto3.ErrorResponse{Severity: "FATAL", Code: "28P01", Message: "denied"})
+	assert.Equal(t, "28P01", errorResponseCode(data[5:]))
+	assert.Equal(t, "", errorResponseCode([]byte{'S', 'F'}))
+	assert.Equal(t, "", errorResponseCode(nil))
+}
+
+func TestTokenCacheInvalidateInvalidatesCredentials(t *testing.T) {
+	var calls atomic.Int32
+	creds := aws.NewCredentialsCache(countingCredentials(&calls))
+	cache := NewTokenCache(NewSigV4TokenProvider(creds, 10*time.Minute), DefaultTokenRefreshFraction)
+	ctx := context.Background()
+
+	_, _, err := cache.Token(ctx, testHost, "us-east-1", "admin")
+	require.NoError(t, err)
+	_, _, err = cache.Token(ctx, testHost, "us-east-1", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+
+	invalidateTokenProvider(cache)
+
+	_, _, err = cache.Token(ctx, testHost, "us-east-1", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestNewPoolInstallsAuthFailureDetector(t *testing.T) {
+	ctx := context.Background()
+	provider := &staticTokenProvider{token: "sidecar-token"}
+
+	pool, err := NewPool(ctx, Config{Host: "mycluster.dsql.us-east-1.on.aws", TokenProvider: provider})
+	require.NoError(t, err)
+	defer pool.Close()
+	assert.NotNil(t, pool.Config().ConnConfig.AfterNetConnect)
+
+	disabled, err := NewPool(ctx, Config{Host: "mycluster.dsql.us-east-1.on.aws", TokenProvider: provider, DisableAuthRetry: true})
+	require.NoError(t, err)
+	defer disabled.Close()
+	assert.NotNil(t, disabled.Config().ConnConfig.AfterNetConnect)
+}
+
+// sequenceTokenProvider returns token-1, token-2, and so on.
+type sequenceTokenProvider struct {
+	calls atomic.Int32
+}
+
+func (p *sequenceTokenProvider) Token(ctx context.Context, host, region, user string) (string, time.Time, error) {
+	return fmt.Sprintf("token-%d", p.calls.Add(1)), time.Time{}, nil
+}
+
+// servePasswordAuth serves the startup handshake over TLS, asking for a
+// cleartext password and rejecting it with 28P01 unless accept returns true.
+func servePasswordAuth(conn net.Conn, tlsConfig *tls.Config, accept func(password string) bool) {
+	msg, err := pgproto3.NewBackend(conn, conn).ReceiveStartupMessage()
+	if _, ok := msg.(*pgproto3.SSLRequest); err != nil || !ok {
+		return
+	}
+	if _, err := conn.Write([]byte("S")); err != nil {
+		return
+	}
+	tlsConn := tls.Server(conn, tlsConfig)
+	backend := pgproto3.NewBackend(tlsConn, tlsConn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationCleartextPassword{})
+	if backend.Flush() != nil {
+		return
+	}
+	backend.SetAuthType(pgproto3.AuthTypeCleartextPassword)
+	reply, err := backend.Receive()
+	if err != nil {
+		return
+	}
+	password, ok := reply.(*pgproto3.PasswordMessage)
+	if !ok || !accept(password.Password) {
+		backend.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: ErrorCodeInvalidPassword, Message: "access denied"})
+		_ = backend.Flush()
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if backend.Flush() != nil {
+		return
+	}
+	for {
+		if _, err := backend.Receive(); err != nil {
+			return
+		}
+	}
+}
+
+// newPasswordAuthConfig returns a Config for a server that accepts only the
+// passwords accepted by accept, and counts dials.
+func newPasswordAuthConfig(t *testing.T, dials *atomic.Int32, provider TokenProvider, accept func(string) bool) Config {
+	pki := newTestPKI(t, testHost)
+	addr := serve(t, func(conn net.Conn) {
+		servePasswordAuth(conn, &tls.Config{Certificates: []tls.Certificate{pki.server}}, accept)
+	})
+	return Config{
+		Host:          testHost,
+		TLS:           &TLSConfig{RootCAs: pki.pool()},
+		TokenProvider: provider,
+		Dialer: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dials.Add(1)
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+}
+
+func TestPoolRetriesRejectedAuthentication(t *testing.T) {
+	ctx := context.Background()
+	var dials atomic.Int32
+	provider := &sequenceTokenProvider{}
+	cfg := newPasswordAuthConfig(t, &dials, provider, func(password string) bool {
+		return password != "token-1"
+	})
+
+	pool, err := NewPool(ctx, cfg)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	conn, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+	conn.Release()
+	assert.Equal(t, int32(2), dials.Load())
+	assert.Equal(t, int32(2), provider.calls.Load())
+}
+
+func TestPoolAuthRetriesExhausted(t *testing.T) {
+	ctx := context.Background()
+	var dials atomic.Int32
+	provider := &sequenceTokenProvider{}
+	cfg := newPasswordAuthConfig(t, &dials, provider, func(string) bool { return false })
+	cfg.MaxAuthRetries = 1
+
+	pool, err := NewPool(ctx, cfg)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Acquire(ctx)
+	require.Error(t, err)
+	var authErr *AuthError
+	require.ErrorAs(t, err, &authErr)
+	assert.Equal(t, testHost, authErr.Host)
+	assert.Equal(t, DefaultUser, authErr.User)
+	assert.True(t, IsAuthError(err))
+	assert.Equal(t, int32(2), dials.Load())
+
+	dials.Store(0)
+	cfg.DisableAuthRetry = true
+	disabled, err := NewPool(ctx, cfg)
+	require.NoError(t, err)
+	defer disabled.Close()
+
+	_, err = disabled.Acquire(ctx)
+	require.ErrorAs(t, err, &authErr)
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, ErrorCodeInvalidPassword, pgErr.Code)
+	assert.Equal(t, int32(1), dials.Load())
+	assert.Equal(t, int32(3), provider.calls.Load())
+}
+
+func TestPgxVersionSupported(t *testing.T) {
+	assert.True(t, pgxVersionSupported("v5.8.0"))
+	assert.True(t, pgxVersionSupported("v5.8.12"))
+	assert.False(t, pgxVersionSupported("v5.80.0"))
+	assert.False(t, pgxVersionSupported("v5.9.0"))
+	assert.False(t, pgxVersionSupported("(devel)"))
+
+	// Fails when go.mod moves to a pgx version that TestPgconnHandshakeContract
+	// has not been checked against.
+	assert.True(t, inConnectionRetrySupported(), "update supportedPgxVersion after verifying the handshake contract")
+}
+
+// recordingConn records the bytes written to it.
+type recordingConn struct {
+	net.Conn
+	written []byte
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.written = append(c.written, p...)
+	return c.Conn.Write(p)
+}
+
+// failingReadConn fails every Read with err.
+type failingReadConn struct {
+	net.Conn
+	err error
+}
+
+func (c *failingReadConn) Read([]byte) (int, error) { return 0, c.err }
+
+// TestPgconnHandshakeContract checks the pgconn behaviors that authFailureConn
+// relies on. If it fails after a pgx upgrade, update auth_retry.go before
+// raising supportedPgxVersion.
+func TestPgconnHandshakeContract(t *testing.T) {
+	ctx := context.Background()
+	pki := newTestPKI(t, testHost)
+	passwords := make(chan string, 1)
+	addr := serve(t, func(conn net.Conn) {
+		servePasswordAuth(conn, &tls.Config{Certificates: []tls.Certificate{pki.server}}, func(password string) bool {
+			passwords <- password
+			return true
+		})
+	})
+
+	newConfig := func(dials *atomic.Int32) *pgconn.Config {
+		cfg, err := pgconn.ParseConfig("")
+		require.NoError(t, err)
+		cfg.Host = testHost
+		cfg.User = DefaultUser
+		cfg.Password = "original"
+		cfg.TLSConfig = &tls.Config{ServerName: testHost, RootCAs: pki.pool()}
+		cfg.Fallbacks = nil
+		cfg.LookupFunc = lookupPassthrough
+		cfg.DialFunc = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dials.Add(1)
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}
+		return cfg
+	}
+
+	// The hook gets the TLS connection and the config that the password is
+	// read from, and the startup message is the first thing written.
+	var dials atomic.Int32
+	cfg := newConfig(&dials)
+	var recorded *recordingConn
+	cfg.AfterNetConnect = func(ctx context.Context, hookConfig *pgconn.Config, conn net.Conn) (net.Conn, error) {
+		_, isTLS := conn.(*tls.Conn)
+		assert.True(t, isTLS, "AfterNetConnect must be called after TLS is negotiated")
+		hookConfig.Password = "from-hook"
+		recorded = &recordingConn{Conn: conn}
+		return recorded, nil
+	}
+	conn, err := pgconn.ConnectConfig(ctx, cfg)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close(ctx))
+	assert.Equal(t, "from-hook", <-passwords)
+	require.GreaterOrEqual(t, len(recorded.written), 8)
+	assert.Equal(t, uint32(pgproto3.ProtocolVersionNumber), binary.BigEndian.Uint32(recorded.written[4:8]))
+
+	// A read error is returned in the connect error, after a cancel request
+	// is dialed with the DialFunc of the hook's config.
+	sentinel := errors.New("sentinel read error")
+	var cancelDials atomic.Int32
+	cfg = newConfig(&dials)
+	cfg.AfterNetConnect = func(ctx context.Context, hookConfig *pgconn.Config, conn net.Conn) (net.Conn, error) {
+		hookConfig.DialFunc = func(context.Context, string, string) (net.Conn, error) {
+			cancelDials.Add(1)
+			return nil, errors.New("refused")
+		}
+		return &failingReadConn{Conn: conn, err: sentinel}, nil
+	}
+	_, err = pgconn.ConnectConfig(ctx, cfg)
+	assert.ErrorIs(t, err, sentinel)
+	assert.Eventually(t, func() bool { return cancelDials.Load() == 1 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TimeSource returns a trusted reference time, such as one obtained from an NTP
+// server or an HTTP Date header. It is used to measure the skew of the local clock.
+type TimeSource func(ctx context.Context) (time.Time, error)
+
+// clockSkewRemeasureInterval is how long a skew measurement is trusted before
+// it is taken again, so that slow drift on long-running hosts is followed.
+const clockSkewRemeasureInterval = time.Hour
+
+// minClockSkew is the smallest skew that is compensated for. Smaller offsets are
+// within the error of a round-trip measurement and well inside SigV4's tolerance.
+const minClockSkew = time.Second
+
+// skewClock tracks the measured offset between the local clock and a reference
+// clock, and reports the local time corrected by that offset.
+type skewClock struct {
+	// now returns the local time. Overridden in tests.
+	now func() time.Time
+
+	mu         sync.Mutex
+	skew       time.Duration
+	measured   bool
+	measuredAt time.Time
+}
+
+func newSkewClock() *skewClock {
+	return &skewClock{now: time.Now}
+}
+
+// Now returns the local time corrected for the measured skew.
+func (c *skewClock) Now() time.Time {
+	return c.now().Add(c.offset())
+}
+
+// offset returns the correction applied by Now.
+func (c *skewClock) offset() time.Duration {
+	skew, ok := c.Skew()
+	if !ok || (skew < minClockSkew && skew > -minClockSkew) {
+		return 0
+	}
+	return skew
+}
+
+// Skew returns the last measured skew, positive when the local clock is behind
+// the reference, and whether a measurement has been taken.
+func (c *skewClock) Skew() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skew, c.measured
+}
+
+// due reports whether the skew should be measured.
+func (c *skewClock) due() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.measuredAt.IsZero() || c.now().Sub(c.measuredAt) >= clockSkewRemeasureInterval
+}
+
+// record stores a measurement of reference, observed between the local times
+// before and after, and returns the skew. The reference is assumed to have been
+// taken halfway through the round trip.
+func (c *skewClock) record(before, reference, after time.Time) time.Duration {
+	skew := reference.Sub(before.Add(after.Sub(before) / 2))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skew = skew
+	c.measured = true
+	c.measuredAt = after
+	return skew
+}
+
+// Invalidate forces the skew to be measured again, after an authentication
+// rejection that may have been caused by a changed clock. The previous
+// measurement stays in use until then.
+func (c *skewClock) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.measuredAt = time.Time{}
+}
+
+// measure takes a measurement against source if one is due and calls onSkew
+// with the result.
+func (c *skewClock) measure(ctx context.Context, source TimeSource, onSkew func(time.Duration)) error {
+	if !c.due() {
+		return nil
+	}
+
+	before := c.now()
+	reference, err := source(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to measure clock skew: %w", err)
+	}
+	skew := c.record(before, reference, c.now())
+
+	if onSkew != nil {
+		onSkew(skew)
+	}
+	return nil
+}
+
+// serverTimeSource returns a TimeSource that reads the server's clock over conn.
+func serverTimeSource(conn *pgx.Conn) TimeSource {
+	return func(ctx context.Context) (time.Time, error) {
+		var serverNow time.Time
+		if err := conn.QueryRow(ctx, "SELECT now()").Scan(&serverNow); err != nil {
+			return time.Time{}, err
+		}
+		return serverNow, nil
+	}
+}
+
+// compensatesSkew reports whether tokens are signed with a skewClock: clock skew
+// compensation is enabled and the connector signs tokens itself.
+func (r *resolvedConfig) compensatesSkew() bool {
+	return r.ClockSkewCompensation && r.TokenProvider == nil
+}
+
+// measureServerSkew measures the skew against the server over a newly
+// established connection, unless a TimeSource is configured or no measurement
+// is due. A failed measurement leaves the previous one in place; it does not
+// fail the connection.
+func (r *resolvedConfig) measureServerSkew(ctx context.Context, clock *skewClock, conn *pgx.Conn) {
+	if clock == nil || r.TimeSource != nil {
+		return
+	}
+	_ = clock.measure(ctx, serverTimeSource(conn), r.OnClockSkew)
+}
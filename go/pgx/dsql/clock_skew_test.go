@@ -0,0 +1,152 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkewClockMeasure(t *testing.T) {
+	local := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := newSkewClock()
+	clock.now = func() time.Time { return local }
+
+	var reported []time.Duration
+	source := func(context.Context) (time.Time, error) { return local.Add(10 * time.Minute), nil }
+	require.NoError(t, clock.measure(context.Background(), source, func(skew time.Duration) {
+		reported = append(reported, skew)
+	}))
+
+	assert.Equal(t, []time.Duration{10 * time.Minute}, reported)
+	skew, ok := clock.Skew()
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Minute, skew)
+	assert.Equal(t, local.Add(10*time.Minute), clock.Now())
+
+	// Not measured again until the interval elapses or the clock is invalidated.
+	require.NoError(t, clock.measure(context.Background(), source, func(skew time.Duration) {
+		reported = append(reported, skew)
+	}))
+	assert.Len(t, reported, 1)
+
+	clock.Invalidate()
+	assert.True(t, clock.due())
+	skew, ok = clock.Skew()
+	assert.True(t, ok, "the previous measurement stays in use after invalidation")
+	assert.Equal(t, 10*time.Minute, skew)
+
+	clock.record(local, local, local)
+	local = local.Add(clockSkewRemeasureInterval)
+	assert.True(t, clock.due())
+}
+
+func TestSkewClockIgnoresSmallSkew(t *testing.T) {
+	clock := newSkewClock()
+	base := time.Now()
+	clock.record(base, base.Add(200*time.Millisecond), base)
+
+	assert.Equal(t, time.Duration(0), clock.offset())
+	skew, ok := clock.Skew()
+	assert.True(t, ok)
+	assert.Equal(t, 200*time.Millisecond, skew)
+}
+
+func TestSkewClockMeasureError(t *testing.T) {
+	clock := newSkewClock()
+	err := clock.measure(context.Background(), func(context.Context) (time.Time, error) {
+		return time.Time{}, errors.New("ntp unreachable")
+	}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ntp unreachable")
+	assert.True(t, clock.due())
+}
+
+func TestGenerateTokenAt(t *testing.T) {
+	var calls atomic.Int32
+	ctx := context.Background()
+	signingTime := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	token, err := GenerateTokenAt(ctx, testHost, "us-east-1", "admin", countingCredentials(&calls), 5*time.Minute, signingTime)
+	require.NoError(t, err)
+
+	expiresAt, err := tokenExpiresAt(token)
+	require.NoError(t, err)
+	assert.Equal(t, signingTime.Add(5*time.Minute), expiresAt)
+
+	// The token has the same shape as one generated by the AWS SDK.
+	sdkToken, err := GenerateToken(ctx, testHost, "us-east-1", "admin", countingCredentials(&calls), 5*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, queryKeys(t, sdkToken), queryKeys(t, token))
+
+	query, err := tokenQuery(token)
+	require.NoError(t, err)
+	assert.Equal(t, actionDbConnectAdmin, query.Get("Action"))
+	assert.Contains(t, query.Get("X-Amz-Credential"), "/us-east-1/dsql/aws4_request")
+	assert.True(t, strings.HasPrefix(token, testHost+"?"))
+}
+
+func queryKeys(t *testing.T, token string) []string {
+	t.Helper()
+	query, err := tokenQuery(token)
+	require.NoError(t, err)
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestSigV4TokenProviderCompensatesSkew(t *testing.T) {
+	var calls atomic.Int32
+	var reported atomic.Int64
+	resolved, err := (&Config{
+		Host:              testHost,
+		TokenDurationSecs: 600,
+		TimeSource: func(context.Context) (time.Time, error) {
+			return time.Now().Add(-20 * time.Minute), nil
+		},
+		OnClockSkew: func(skew time.Duration) { reported.Store(int64(skew)) },
+	}).resolve()
+	require.NoError(t, err)
+	assert.True(t, resolved.ClockSkewCompensation)
+
+	provider := resolved.newSigV4TokenProvider(countingCredentials(&calls), newSkewClock())
+	token, expiresAt, err := provider.Token(context.Background(), testHost, "us-east-1", "admin")
+	require.NoError(t, err)
+
+	assert.InDelta(t, float64(-20*time.Minute), float64(reported.Load()), float64(time.Second))
+
+	// Signed with the reference time, but the expiry is reported in local time.
+	signedExpiry, err := tokenExpiresAt(token)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(-10*time.Minute), signedExpiry, 2*time.Second)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), expiresAt, 2*time.Second)
+}
+
+func TestAuthErrorReportsClockSkew(t *testing.T) {
+	resolved := &resolvedConfig{Host: testHost, User: "admin"}
+	clock := newSkewClock()
+	base := time.Now()
+	clock.record(base, base.Add(7*time.Minute), base)
+
+	authErr := newAuthError(resolved, clock, errors.New("password authentication failed"))
+	assert.Equal(t, 7*time.Minute, authErr.ClockSkew)
+	assert.Contains(t, authErr.Error(), "local clock skew 7m0s")
+
+	authErr = newAuthError(resolved, nil, errors.New("password authentication failed"))
+	assert.Zero(t, authErr.ClockSkew)
+	assert.NotContains(t, authErr.Error(), "skew")
+}
@@ -7,6 +7,7 @@ package dsql
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 // Version is the connector version
@@ -40,63 +42,445 @@ const (
 	DefaultMaxConnIdleTime = 10 * time.Minute
 	// DefaultTokenDuration is the default token validity duration (15 minutes)
 	DefaultTokenDuration = 15 * time.Minute
+	// MaxTokenDuration is the maximum token validity duration (1 week)
+	MaxTokenDuration = 7 * 24 * time.Hour
+	// DefaultMaxAuthRetries is the default number of times a connection attempt
+	// is retried with a fresh token after the server rejects authentication
+	DefaultMaxAuthRetries = 2
+	// DefaultTokenRefreshFraction is the fraction of a token's lifetime after
+	// which a cached token is refreshed in the background (80%)
+	DefaultTokenRefreshFraction = 0.8
 )
 
 // Config holds the configuration for connecting to Aurora DSQL.
 type Config struct {
-	// Host is the cluster endpoint or cluster ID. Required.
-	Host string
+	// Host is the cluster endpoint, cluster ID or cluster ARN. Required.
+	Host string `json:"host,omitempty" yaml:"host,omitempty" toml:"host,omitempty"`
 
-	// Region is the AWS region. Optional if parseable from Host.
-	Region string
+	// Region is the AWS region. Optional if parseable from Host or DialHost.
+	Region string `json:"region,omitempty" yaml:"region,omitempty" toml:"region,omitempty"`
+
+	// EndpointVariant selects the FIPS or dual-stack endpoint built when Host is
+	// a cluster ID or ARN. The DNS suffix follows the region's partition. It is
+	// ignored when Host is a hostname. Optional. Default: EndpointStandard.
+	EndpointVariant EndpointVariant `json:"endpointVariant,omitempty" yaml:"endpointVariant,omitempty" toml:"endpointVariant,omitempty"`
+
+	// DialHost is the address connected to instead of Host, such as the DNS name
+	// of an interface VPC endpoint (AWS PrivateLink). Optional. Tokens are still
+	// generated for Host, which is also the TLS server name.
+	DialHost string `json:"dialHost,omitempty" yaml:"dialHost,omitempty" toml:"dialHost,omitempty"`
+
+	// Dialer opens network connections to the cluster, for example through a
+	// proxy created with NewProxyDialer or ProxyFromEnvironment, or an SSH
+	// client's DialContext. Optional. The dialer is given the unresolved
+	// hostname, so that a proxy can resolve it. Default: a direct connection,
+	// or the DialFunc of a provided pool config.
+	Dialer pgconn.DialFunc `json:"-" yaml:"-" toml:"-"`
 
 	// User is the database user. Default: "admin".
-	User string
+	User string `json:"user,omitempty" yaml:"user,omitempty" toml:"user,omitempty"`
 
 	// Database is the database name. Default: "postgres".
-	Database string
+	Database string `json:"database,omitempty" yaml:"database,omitempty" toml:"database,omitempty"`
 
 	// Port is the database port. Default: 5432.
-	Port int
+	Port int `json:"port,omitempty" yaml:"port,omitempty" toml:"port,omitempty"`
 
 	// Profile is the AWS profile name for credentials. Optional.
-	Profile string
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty" toml:"profile,omitempty"`
 
 	// TokenDurationSecs is the token validity duration in seconds. Optional.
-	TokenDurationSecs int
+	TokenDurationSecs int `json:"tokenDurationSecs,omitempty" yaml:"tokenDurationSecs,omitempty" toml:"tokenDurationSecs,omitempty"`
+
+	// TokenRefreshFraction is the fraction of the token lifetime after which a
+	// cached token is refreshed in the background. Must be in the range (0, 1].
+	// Default: 0.8.
+	TokenRefreshFraction float64 `json:"tokenRefreshFraction,omitempty" yaml:"tokenRefreshFraction,omitempty" toml:"tokenRefreshFraction,omitempty"`
 
 	// CustomCredentialsProvider is a custom AWS credentials provider. Optional.
-	CustomCredentialsProvider aws.CredentialsProvider
+	CustomCredentialsProvider aws.CredentialsProvider `json:"-" yaml:"-" toml:"-"`
+
+	// CredentialSource selects where AWS credentials come from. Optional.
+	// Default: CredentialSourceAuto, which uses CustomCredentialsProvider if set,
+	// then Profile if set, then the AWS SDK default credential chain.
+	CredentialSource CredentialSource `json:"credentialSource,omitempty" yaml:"credentialSource,omitempty" toml:"credentialSource,omitempty"`
+
+	// StaticCredentials are the keys used with CredentialSourceStatic. Optional.
+	StaticCredentials *aws.Credentials `json:"-" yaml:"-" toml:"-"`
+
+	// WebIdentityTokenFile is the path of the web identity token used with
+	// CredentialSourceWebIdentity. Optional; defaults to AWS_WEB_IDENTITY_TOKEN_FILE.
+	WebIdentityTokenFile string `json:"webIdentityTokenFile,omitempty" yaml:"webIdentityTokenFile,omitempty" toml:"webIdentityTokenFile,omitempty"`
+
+	// WebIdentityRoleARN is the role assumed with CredentialSourceWebIdentity.
+	// Optional; defaults to AWS_ROLE_ARN.
+	WebIdentityRoleARN string `json:"webIdentityRoleArn,omitempty" yaml:"webIdentityRoleArn,omitempty" toml:"webIdentityRoleArn,omitempty"`
+
+	// AssumeRole is a chain of IAM roles to assume before generating tokens. Optional.
+	// The first role is assumed with the base credentials (CustomCredentialsProvider,
+	// Profile or the default chain), and each subsequent role with the credentials
+	// of the previous one.
+	AssumeRole []AssumeRoleOptions `json:"assumeRole,omitempty" yaml:"assumeRole,omitempty" toml:"assumeRole,omitempty"`
+
+	// MaxAuthRetries is the number of times a connection attempt, by Connect or
+	// by a pool, is retried with freshly generated credentials and token after
+	// the server rejects authentication. Optional. Default: 2.
+	MaxAuthRetries int `json:"maxAuthRetries,omitempty" yaml:"maxAuthRetries,omitempty" toml:"maxAuthRetries,omitempty"`
+
+	// DisableAuthRetry disables invalidating cached credentials and tokens and
+	// retrying after the server rejects authentication. Optional.
+	DisableAuthRetry bool `json:"disableAuthRetry,omitempty" yaml:"disableAuthRetry,omitempty" toml:"disableAuthRetry,omitempty"`
+
+	// TokenAction selects the IAM action that tokens authorize. Optional.
+	// Default: TokenActionAuto, which uses DbConnectAdmin for the "admin" user
+	// and DbConnect otherwise.
+	TokenAction TokenAction `json:"tokenAction,omitempty" yaml:"tokenAction,omitempty" toml:"tokenAction,omitempty"`
+
+	// ClockSkewCompensation measures the offset between the local clock and the
+	// server's clock after the first successful connection, and signs later tokens
+	// with the corrected time. The measurement is repeated hourly and after an
+	// authentication rejection. Optional. Ignored with a TokenProvider, since the
+	// connector does not sign its tokens.
+	ClockSkewCompensation bool `json:"clockSkewCompensation,omitempty" yaml:"clockSkewCompensation,omitempty" toml:"clockSkewCompensation,omitempty"`
+
+	// TimeSource is a trusted reference clock used to measure skew before tokens
+	// are signed, instead of the server's clock. Optional; setting it enables
+	// clock skew compensation.
+	TimeSource TimeSource `json:"-" yaml:"-" toml:"-"`
+
+	// OnClockSkew is called with each clock skew measurement, positive when the
+	// local clock is behind. Optional.
+	OnClockSkew func(skew time.Duration) `json:"-" yaml:"-" toml:"-"`
+
+	// Params holds standard libpq, pgx and pgxpool connection parameters, such as
+	// connect_timeout, search_path, default_query_exec_mode or pool_max_conns.
+	// Optional. ParseConnectionString stores every such parameter here; unknown
+	// parameters are rejected. Pool parameters apply only when NewPool creates the
+	// pool config.
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty" toml:"params,omitempty"`
+
+	// RuntimeParams are additional session parameters sent to the server at
+	// connection startup, such as custom settings. Optional. They are merged
+	// with the RuntimeParams of a provided pool config and the session
+	// parameters in Params, taking precedence over both.
+	RuntimeParams map[string]string `json:"runtimeParams,omitempty" yaml:"runtimeParams,omitempty" toml:"runtimeParams,omitempty"`
+
+	// ServiceName identifies the application to the server. It is appended to
+	// ApplicationName in the application_name session parameter, as in
+	// "aurora-dsql-go-pgx/1.0.0 orders-svc", unless application_name is set
+	// explicitly. Optional.
+	ServiceName string `json:"serviceName,omitempty" yaml:"serviceName,omitempty" toml:"serviceName,omitempty"`
+
+	// TLS configures server certificate verification. Optional. Default:
+	// verify-full against the system roots with TLS 1.2 or later, adjusted by
+	// the sslmode and sslrootcert connection parameters.
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" toml:"tls,omitempty"`
+
+	// TokenProvider supplies authentication tokens instead of the built-in SigV4
+	// generator. Optional. When set, Profile, CustomCredentialsProvider and
+	// TokenDurationSecs are ignored and tokens are requested from the provider
+	// for every new connection; wrap it with [NewTokenCache] to cache them.
+	TokenProvider TokenProvider `json:"-" yaml:"-" toml:"-"`
+}
+
+// AssumeRoleOptions describes one hop in a chain of IAM role assumptions.
+type AssumeRoleOptions struct {
+	// RoleARN is the ARN of the role to assume. Required.
+	RoleARN string `json:"roleArn,omitempty" yaml:"roleArn,omitempty" toml:"roleArn,omitempty"`
+
+	// ExternalID is the external ID required by the role's trust policy. Optional.
+	ExternalID string `json:"externalId,omitempty" yaml:"externalId,omitempty" toml:"externalId,omitempty"`
+
+	// SessionName is the role session name. Optional; generated by the AWS SDK if empty.
+	SessionName string `json:"sessionName,omitempty" yaml:"sessionName,omitempty" toml:"sessionName,omitempty"`
+
+	// SessionTags are the session tags to pass. Optional.
+	SessionTags map[string]string `json:"sessionTags,omitempty" yaml:"sessionTags,omitempty" toml:"sessionTags,omitempty"`
+
+	// Duration is the role session duration. Optional; must be between 15 minutes
+	// and 12 hours if set. Default: the AWS SDK default (15 minutes).
+	Duration time.Duration `json:"duration,omitempty" yaml:"duration,omitempty" toml:"duration,omitempty"`
+
+	// SourceProfile is the AWS profile providing the base credentials for the
+	// chain. Optional, and only valid on the first hop. Overrides Config.Profile.
+	SourceProfile string `json:"sourceProfile,omitempty" yaml:"sourceProfile,omitempty" toml:"sourceProfile,omitempty"`
 }
 
+// Limits on the role session duration accepted by STS AssumeRole.
+const (
+	minAssumeRoleDuration = 15 * time.Minute
+	maxAssumeRoleDuration = 12 * time.Hour
+)
+
 // resolvedConfig holds the validated and resolved configuration with all
 // defaults applied and the full hostname constructed.
 type resolvedConfig struct {
 	Host                      string
+	DialHost                  string
+	Dialer                    pgconn.DialFunc
 	Region                    string
 	User                      string
 	Database                  string
 	Port                      int
 	Profile                   string
 	TokenDuration             time.Duration
+	TokenRefreshFraction      float64
+	TokenAction               TokenAction
+	AuthRetries               int
 	CustomCredentialsProvider aws.CredentialsProvider
+	CredentialSource          CredentialSource
+	StaticCredentials         *aws.Credentials
+	WebIdentityTokenFile      string
+	WebIdentityRoleARN        string
+	AssumeRole                []AssumeRoleOptions
+	ClockSkewCompensation     bool
+	TimeSource                TimeSource
+	OnClockSkew               func(skew time.Duration)
+	Params                    map[string]string
+	RuntimeParams             map[string]string
+	ServiceName               string
+	TLS                       TLSConfig
+	TLSConfig                 *tls.Config // set by loadTLSConfig
+	TokenProvider             TokenProvider
+}
+
+// Validate checks c for configuration errors without contacting AWS. It returns
+// every problem found, joined with errors.Join, as *FieldError values naming the
+// offending field. The error matches ErrInvalidConfig.
+//
+// NewPool, Connect and the other functions accepting a Config call Validate
+// before any AWS calls.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(field string, err error) {
+		var fieldErr *FieldError
+		switch {
+		case err == nil:
+		case errors.As(err, &fieldErr):
+			errs = append(errs, err)
+		default:
+			errs = append(errs, &FieldError{Field: field, Err: err})
+		}
+	}
+
+	if strings.TrimSpace(c.Host) == "" {
+		check("Host", configErrorf("host is required"))
+	} else if IsVPCEndpoint(c.Host) {
+		check("Host", configErrorf("host %q is a VPC endpoint: set DialHost to it and Host to the cluster endpoint or ID", c.Host))
+	} else if err := validateClusterARN(c.Host); err != nil {
+		check("Host", err)
+	} else {
+		_, region, err := c.resolveEndpoint()
+		check("Region", err)
+		if err == nil && !validRegion(region) {
+			check("Region", configErrorf("malformed region %q", region))
+		}
+	}
+
+	if !validEndpointVariant(c.EndpointVariant) {
+		check("EndpointVariant", configErrorf("unknown endpoint variant %q", c.EndpointVariant))
+	}
+
+	if c.DialHost != "" && strings.TrimSpace(c.DialHost) != c.DialHost {
+		check("DialHost", configErrorf("dial host must not have leading or trailing spaces, got %q", c.DialHost))
+	}
+
+	if c.User != "" && strings.TrimSpace(c.User) == "" {
+		check("User", configErrorf("user must not be blank"))
+	}
+
+	if c.Port != 0 && (c.Port < 1 || c.Port > 65535) {
+		check("Port", configErrorf("port must be between 1 and 65535, got %d", c.Port))
+	}
+
+	switch {
+	case c.TokenDurationSecs < 0:
+		check("TokenDurationSecs", configErrorf("token duration must not be negative, got %d", c.TokenDurationSecs))
+	case time.Duration(c.TokenDurationSecs)*time.Second > MaxTokenDuration:
+		check("TokenDurationSecs", configErrorf("token duration must be at most %d seconds (1 week), got %d",
+			int(MaxTokenDuration/time.Second), c.TokenDurationSecs))
+	}
+
+	if c.TokenRefreshFraction < 0 || c.TokenRefreshFraction > 1 {
+		check("TokenRefreshFraction", configErrorf("token refresh fraction must be between 0 and 1, got %g", c.TokenRefreshFraction))
+	}
+
+	if !validTokenAction(c.TokenAction) {
+		check("TokenAction", configErrorf("unknown token action %q", c.TokenAction))
+	}
+
+	check("Params", validateParams(c.Params))
+
+	for key := range c.RuntimeParams {
+		if strings.TrimSpace(key) == "" {
+			check("RuntimeParams", configErrorf("runtime parameter names must not be blank"))
+			break
+		}
+	}
+
+	if strings.TrimSpace(c.ServiceName) != c.ServiceName {
+		check("ServiceName", configErrorf("service name must not have leading or trailing spaces, got %q", c.ServiceName))
+	}
+
+	if c.TLS != nil {
+		check("TLS", c.TLS.validate())
+	}
+
+	if c.MaxAuthRetries < 0 {
+		check("MaxAuthRetries", configErrorf("max auth retries must not be negative, got %d", c.MaxAuthRetries))
+	}
+
+	for i, hop := range c.AssumeRole {
+		field := fmt.Sprintf("AssumeRole[%d]", i)
+		if hop.RoleARN == "" {
+			check(field, configErrorf("assume role %d: role ARN is required", i))
+		}
+		if hop.SourceProfile != "" && i > 0 {
+			check(field, configErrorf("assume role %d: source profile is only valid on the first role", i))
+		}
+		if hop.Duration != 0 && (hop.Duration < minAssumeRoleDuration || hop.Duration > maxAssumeRoleDuration) {
+			check(field, configErrorf("assume role %d: duration must be between %s and %s, got %s",
+				i, minAssumeRoleDuration, maxAssumeRoleDuration, hop.Duration))
+		}
+	}
+
+	check("CredentialSource", c.validateCredentialSource())
+
+	return errors.Join(errs...)
+}
+
+// validateClusterARN checks host if it is a cluster ARN.
+func validateClusterARN(host string) error {
+	if !IsClusterARN(host) {
+		return nil
+	}
+	if _, err := ParseClusterARN(host); err != nil {
+		return configErrorf("%w", err)
+	}
+	return nil
+}
+
+// resolveEndpoint returns the full hostname and region for c.Host, taking the
+// region from c.Region, the hostname, the dial host or the environment. The
+// regions of the hostname and the dial host must agree.
+func (c *Config) resolveEndpoint() (host, region string, err error) {
+	host, region = c.Host, c.Region
+
+	// A cluster ARN determines the region.
+	if IsClusterARN(host) {
+		arn, err := ParseClusterARN(host)
+		if err != nil {
+			return "", "", configErrorf("%w", err)
+		}
+		if region != "" && region != arn.Region {
+			return "", "", configErrorf("region %q conflicts with region %q of cluster ARN", region, arn.Region)
+		}
+		if !knownPartition(arn.Partition) {
+			return "", "", configErrorf("partition %q of cluster ARN is not supported", arn.Partition)
+		}
+		if p := PartitionForRegion(arn.Region); p != arn.Partition {
+			return "", "", configErrorf("region %q of cluster ARN is not in partition %q", arn.Region, arn.Partition)
+		}
+		host, err := c.buildEndpoint(arn.ClusterID, arn.Region)
+		return host, arn.Region, err
+	}
+
+	// Parse the region from the hostname, or else from the dial host: a VPC
+	// endpoint name identifies the region, but not the cluster.
+	if region == "" {
+		if !IsClusterID(host) {
+			region, _ = ParseRegion(host)
+		}
+		if c.DialHost != "" {
+			dialRegion, _ := ParseRegion(c.DialHost)
+			switch {
+			case region == "":
+				region = dialRegion
+			case dialRegion != "" && dialRegion != region:
+				return "", "", configErrorf("region %q of host conflicts with region %q of dial host", region, dialRegion)
+			}
+		}
+	}
+
+	// Handle cluster ID vs full hostname
+	if IsClusterID(host) {
+		// Need region to build hostname
+		if region == "" {
+			region = getRegionFromEnv()
+		}
+		if region == "" {
+			return "", "", configErrorf("region is required when host is a cluster ID")
+		}
+		host, err := c.buildEndpoint(host, region)
+		return host, region, err
+	}
+
+	if region == "" {
+		// Try environment
+		region = getRegionFromEnv()
+		if region == "" {
+			return "", "", configErrorf("region is required: could not parse from hostname and not set in environment")
+		}
+	}
+	return host, region, nil
+}
+
+// buildEndpoint builds the hostname of c.EndpointVariant for a cluster ID.
+func (c *Config) buildEndpoint(clusterID, region string) (string, error) {
+	if p := partitionForRegion(region); p.dnsSuffix == "" {
+		return "", configErrorf("%w", noEndpointsError(p, region))
+	}
+	if !validEndpointVariant(c.EndpointVariant) {
+		// Reported by Validate.
+		return BuildHostname(clusterID, region), nil
+	}
+	host, err := BuildEndpoint(clusterID, region, c.EndpointVariant)
+	if err != nil {
+		return "", &FieldError{Field: "EndpointVariant", Err: configErrorf("%w", err)}
+	}
+	return host, nil
 }
 
 // resolve validates the configuration, applies defaults, and resolves the
 // full hostname and region.
 func (c *Config) resolve() (*resolvedConfig, error) {
-	if c.Host == "" {
-		return nil, fmt.Errorf("host is required")
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	host, region, err := c.resolveEndpoint()
+	if err != nil {
+		return nil, err
 	}
 
 	resolved := &resolvedConfig{
-		Host:                      c.Host,
-		Region:                    c.Region,
+		Host:                      host,
+		DialHost:                  c.DialHost,
+		Dialer:                    c.Dialer,
+		Region:                    region,
 		User:                      c.User,
 		Database:                  c.Database,
 		Port:                      c.Port,
 		Profile:                   c.Profile,
+		TokenRefreshFraction:      c.TokenRefreshFraction,
 		CustomCredentialsProvider: c.CustomCredentialsProvider,
+		CredentialSource:          c.CredentialSource,
+		StaticCredentials:         c.StaticCredentials,
+		WebIdentityTokenFile:      c.WebIdentityTokenFile,
+		WebIdentityRoleARN:        c.WebIdentityRoleARN,
+		AssumeRole:                c.AssumeRole,
+		TokenAction:               c.TokenAction,
+		AuthRetries:               c.MaxAuthRetries,
+		ClockSkewCompensation:     c.ClockSkewCompensation || c.TimeSource != nil,
+		TimeSource:                c.TimeSource,
+		OnClockSkew:               c.OnClockSkew,
+		Params:                    c.Params,
+		RuntimeParams:             c.RuntimeParams,
+		ServiceName:               c.ServiceName,
+		TokenProvider:             c.TokenProvider,
+	}
+	if c.TLS != nil {
+		resolved.TLS = *c.TLS
 	}
 
 	// Apply defaults
@@ -109,9 +493,6 @@ func (c *Config) resolve() (*resolvedConfig, error) {
 	if resolved.Port == 0 {
 		resolved.Port = DefaultPort
 	}
-	if resolved.Port < 1 || resolved.Port > 65535 {
-		return nil, fmt.Errorf("port must be between 1 and 65535, got %d", resolved.Port)
-	}
 
 	// Convert token duration with default
 	if c.TokenDurationSecs > 0 {
@@ -120,35 +501,38 @@ func (c *Config) resolve() (*resolvedConfig, error) {
 		resolved.TokenDuration = DefaultTokenDuration
 	}
 
-	// Handle cluster ID vs full hostname
-	if IsClusterID(resolved.Host) {
-		// Need region to build hostname
-		if resolved.Region == "" {
-			resolved.Region = getRegionFromEnv()
-		}
-		if resolved.Region == "" {
-			return nil, fmt.Errorf("region is required when host is a cluster ID")
-		}
-		resolved.Host = BuildHostname(c.Host, resolved.Region)
-	} else {
-		// Try to parse region from hostname if not provided
-		if resolved.Region == "" {
-			parsedRegion, err := ParseRegion(resolved.Host)
-			if err != nil {
-				// Try environment
-				resolved.Region = getRegionFromEnv()
-				if resolved.Region == "" {
-					return nil, fmt.Errorf("region is required: could not parse from hostname and not set in environment")
-				}
-			} else {
-				resolved.Region = parsedRegion
-			}
-		}
+	if resolved.TokenRefreshFraction == 0 {
+		resolved.TokenRefreshFraction = DefaultTokenRefreshFraction
+	}
+
+	switch {
+	case c.DisableAuthRetry:
+		resolved.AuthRetries = 0
+	case c.MaxAuthRetries == 0:
+		resolved.AuthRetries = DefaultMaxAuthRetries
 	}
 
 	return resolved, nil
 }
 
+// toConfig converts the config argument accepted by NewPool and Connect,
+// which can be a Config struct, *Config, or a connection string.
+func toConfig(config any) (*Config, error) {
+	switch c := config.(type) {
+	case Config:
+		return &c, nil
+	case *Config:
+		if c == nil {
+			return nil, configErrorf("config cannot be nil")
+		}
+		return c, nil
+	case string:
+		return ParseConnectionString(c)
+	default:
+		return nil, configErrorf("config must be Config, *Config, or string, got %T", config)
+	}
+}
+
 // getRegionFromEnv returns the AWS region from environment variables.
 func getRegionFromEnv() string {
 	if region := os.Getenv("AWS_REGION"); region != "" {
@@ -158,8 +542,19 @@ func getRegionFromEnv() string {
 }
 
 // ParseConnectionString parses a PostgreSQL or DSQL connection string into a Config.
-// Supported schemes: postgres://, postgresql://, dsql://
+// Supported URL schemes: postgres://, postgresql://, dsql://
+// Keyword/value strings such as "host=... user=... region=..." are also accepted,
+// with the same parameters as the URL query string. A cluster ARN may be given
+// on its own, as the host of a keyword/value string, or as the host query
+// parameter of a URL. Only the assumeRole* parameters may be given more than once.
 func ParseConnectionString(connStr string) (*Config, error) {
+	if IsClusterARN(connStr) {
+		return &Config{Host: connStr}, nil
+	}
+	if isKeywordValueDSN(connStr) {
+		return parseKeywordValueDSN(connStr)
+	}
+
 	// Normalize dsql:// to postgres:// for URL parsing
 	normalizedConnStr := connStr
 	if strings.HasPrefix(connStr, "dsql://") {
@@ -168,7 +563,7 @@ func ParseConnectionString(connStr string) (*Config, error) {
 
 	u, err := url.Parse(normalizedConnStr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid connection string: %w", err)
+		return nil, configErrorf("invalid connection string: %w", err)
 	}
 
 	cfg := &Config{
@@ -186,14 +581,30 @@ func ParseConnectionString(connStr string) (*Config, error) {
 	if portStr := u.Port(); portStr != "" {
 		port, err := strconv.ParseUint(portStr, 10, 16)
 		if err != nil {
-			return nil, fmt.Errorf("invalid port: %w", err)
+			return nil, configErrorf("invalid port: %w", err)
 		}
 		cfg.Port = int(port)
 	}
 
-	// Parse query parameters
+	// As in libpq, a host parameter overrides the host of the URL. This allows
+	// hosts that are not valid in a URL, such as cluster ARNs.
 	query := u.Query()
+	if err := checkRepeatedParams(query); err != nil {
+		return nil, err
+	}
+	if host := query.Get("host"); host != "" {
+		cfg.Host = host
+	}
+	query.Del("host")
 
+	if err := parseConnParams(cfg, query); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseConnParams applies the DSQL connection string parameters in query to cfg.
+func parseConnParams(cfg *Config, query url.Values) error {
 	if region := query.Get("region"); region != "" {
 		cfg.Region = region
 	}
@@ -205,25 +616,192 @@ func ParseConnectionString(connStr string) (*Config, error) {
 	if tokenDuration := query.Get("tokenDurationSecs"); tokenDuration != "" {
 		duration, err := strconv.Atoi(tokenDuration)
 		if err != nil {
-			return nil, fmt.Errorf("invalid tokenDurationSecs: %w", err)
+			return configErrorf("invalid tokenDurationSecs: %w", err)
 		}
 		cfg.TokenDurationSecs = duration
 	}
 
-	return cfg, nil
+	if fraction := query.Get("tokenRefreshFraction"); fraction != "" {
+		value, err := strconv.ParseFloat(fraction, 64)
+		if err != nil {
+			return configErrorf("invalid tokenRefreshFraction: %w", err)
+		}
+		cfg.TokenRefreshFraction = value
+	}
+
+	if retries := query.Get("maxAuthRetries"); retries != "" {
+		value, err := strconv.Atoi(retries)
+		if err != nil {
+			return configErrorf("invalid maxAuthRetries: %w", err)
+		}
+		cfg.MaxAuthRetries = value
+	}
+
+	if disable := query.Get("disableAuthRetry"); disable != "" {
+		value, err := strconv.ParseBool(disable)
+		if err != nil {
+			return configErrorf("invalid disableAuthRetry: %w", err)
+		}
+		cfg.DisableAuthRetry = value
+	}
+
+	if source := query.Get("credentialSource"); source != "" {
+		cfg.CredentialSource = CredentialSource(source)
+	}
+
+	if tokenFile := query.Get("webIdentityTokenFile"); tokenFile != "" {
+		cfg.WebIdentityTokenFile = tokenFile
+	}
+
+	if roleARN := query.Get("webIdentityRoleArn"); roleARN != "" {
+		cfg.WebIdentityRoleARN = roleARN
+	}
+
+	if action := query.Get("tokenAction"); action != "" {
+		cfg.TokenAction = TokenAction(action)
+	}
+
+	if compensate := query.Get("clockSkewCompensation"); compensate != "" {
+		enabled, err := strconv.ParseBool(compensate)
+		if err != nil {
+			return configErrorf("invalid clockSkewCompensation: %w", err)
+		}
+		cfg.ClockSkewCompensation = enabled
+	}
+
+	if variant := query.Get("endpointVariant"); variant != "" {
+		cfg.EndpointVariant = EndpointVariant(variant)
+	}
+
+	if dialHost := query.Get("dialHost"); dialHost != "" {
+		cfg.DialHost = dialHost
+	}
+
+	if serviceName := query.Get("serviceName"); serviceName != "" {
+		cfg.ServiceName = serviceName
+	}
+
+	assumeRole, err := parseAssumeRoleParams(query)
+	if err != nil {
+		return err
+	}
+	cfg.AssumeRole = assumeRole
+
+	// Everything else must be a standard connection parameter.
+	for key, values := range query {
+		if dsqlParams[key] {
+			continue
+		}
+		if cfg.Params == nil {
+			cfg.Params = make(map[string]string)
+		}
+		cfg.Params[key] = values[0]
+	}
+	return validateParams(cfg.Params)
+}
+
+// parseAssumeRoleParams builds an assume-role chain from connection string
+// parameters. Each assumeRoleArn value starts a hop; the other assumeRole*
+// parameters may be repeated and apply to the hops in order, with an empty
+// value leaving that option unset for the corresponding hop.
+func parseAssumeRoleParams(query url.Values) ([]AssumeRoleOptions, error) {
+	arns := query["assumeRoleArn"]
+	optionParams := []string{
+		"assumeRoleExternalId",
+		"assumeRoleSessionName",
+		"assumeRoleSessionTags",
+		"assumeRoleDurationSecs",
+		"assumeRoleSourceProfile",
+	}
+	for _, param := range optionParams {
+		if len(query[param]) > len(arns) {
+			return nil, configErrorf("%s given %d times but only %d assumeRoleArn values", param, len(query[param]), len(arns))
+		}
+	}
+	if len(arns) == 0 {
+		return nil, nil
+	}
+
+	// value returns the i-th value of param, or "" if there is none.
+	value := func(param string, i int) string {
+		if values := query[param]; i < len(values) {
+			return values[i]
+		}
+		return ""
+	}
+
+	hops := make([]AssumeRoleOptions, len(arns))
+	for i, arn := range arns {
+		hop := AssumeRoleOptions{
+			RoleARN:       arn,
+			ExternalID:    value("assumeRoleExternalId", i),
+			SessionName:   value("assumeRoleSessionName", i),
+			SourceProfile: value("assumeRoleSourceProfile", i),
+		}
+		if tags := value("assumeRoleSessionTags", i); tags != "" {
+			hop.SessionTags = make(map[string]string)
+			for _, pair := range strings.Split(tags, ",") {
+				key, val, ok := strings.Cut(pair, ":")
+				if !ok || key == "" {
+					return nil, configErrorf("invalid assumeRoleSessionTags: expected key:value pairs, got %q", pair)
+				}
+				hop.SessionTags[key] = val
+			}
+		}
+		if durationSecs := value("assumeRoleDurationSecs", i); durationSecs != "" {
+			secs, err := strconv.Atoi(durationSecs)
+			if err != nil {
+				return nil, configErrorf("invalid assumeRoleDurationSecs: %w", err)
+			}
+			hop.Duration = time.Duration(secs) * time.Second
+		}
+		hops[i] = hop
+	}
+	return hops, nil
 }
 
 // configureConnConfig sets connection parameters on a pgx.ConnConfig.
 func (r *resolvedConfig) configureConnConfig(cfg *pgx.ConnConfig) {
 	cfg.Host = r.Host
+	if r.DialHost != "" {
+		cfg.Host = r.DialHost
+	}
+	if r.Dialer != nil {
+		cfg.DialFunc = r.Dialer
+		cfg.LookupFunc = lookupPassthrough
+	}
 	cfg.Port = uint16(r.Port)
 	cfg.Database = r.Database
 	cfg.User = r.User
-	cfg.TLSConfig = &tls.Config{
-		ServerName: r.Host,
-		MinVersion: tls.VersionTLS12,
+	cfg.TLSConfig = r.TLSConfig.Clone()
+	// DSQL requires TLS, so never fall back to a plaintext connection.
+	cfg.Fallbacks = nil
+
+	// Merge rather than replace, keeping params set on a provided pool config.
+	// Configs parsed by the connector have had PG* settings cleared.
+	runtimeParams := make(map[string]string, len(cfg.RuntimeParams)+len(r.RuntimeParams)+1)
+	for key, value := range cfg.RuntimeParams {
+		runtimeParams[key] = value
 	}
-	cfg.RuntimeParams = map[string]string{
-		"application_name": ApplicationName,
+	for key, value := range r.Params {
+		if sessionParams[key] {
+			runtimeParams[key] = value
+		}
+	}
+	for key, value := range r.RuntimeParams {
+		runtimeParams[key] = value
+	}
+	if runtimeParams["application_name"] == "" {
+		runtimeParams["application_name"] = r.applicationName()
+	}
+	cfg.RuntimeParams = runtimeParams
+}
+
+// applicationName returns the application_name reported to the server: the
+// connector's ApplicationName, followed by the service name if set.
+func (r *resolvedConfig) applicationName() string {
+	if r.ServiceName == "" {
+		return ApplicationName
 	}
+	return ApplicationName + " " + r.ServiceName
 }
@@ -0,0 +1,553 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gopkg.in/yaml.v3"
+
+	"github.com/awslabs/aurora-dsql-connectors/go/pgx/occretry"
+)
+
+// DefaultEnvironment is the name of the config file section whose settings
+// apply to every environment.
+const DefaultEnvironment = "default"
+
+// FileConfig holds the settings of one environment loaded by LoadConfigFile.
+// Its struct tags, and those of Config, PoolSettings and RetrySettings, allow
+// the types to be embedded in application config decoded with
+// gopkg.in/yaml.v3, github.com/BurntSushi/toml or encoding/json. Durations are
+// strings such as "30s", except with encoding/json, which requires integers of
+// nanoseconds.
+type FileConfig struct {
+	// DSQL is the connector configuration.
+	DSQL Config `json:"dsql" yaml:"dsql" toml:"dsql"`
+
+	// Pool holds pgxpool settings. Nil if the environment has none.
+	Pool *PoolSettings `json:"pool,omitempty" yaml:"pool,omitempty" toml:"pool,omitempty"`
+
+	// Retry holds OCC retry settings, starting from occretry.DefaultConfig. Nil
+	// if the environment has none.
+	Retry *RetrySettings `json:"retry,omitempty" yaml:"retry,omitempty" toml:"retry,omitempty"`
+}
+
+// RetrySettings are OCC retry settings for the occretry package.
+type RetrySettings struct {
+	MaxRetries  int           `json:"maxRetries,omitempty" yaml:"maxRetries,omitempty" toml:"maxRetries,omitempty"`
+	InitialWait time.Duration `json:"initialWait,omitempty" yaml:"initialWait,omitempty" toml:"initialWait,omitempty"`
+	MaxWait     time.Duration `json:"maxWait,omitempty" yaml:"maxWait,omitempty" toml:"maxWait,omitempty"`
+	Multiplier  float64       `json:"multiplier,omitempty" yaml:"multiplier,omitempty" toml:"multiplier,omitempty"`
+}
+
+// defaultRetrySettings returns the settings of occretry.DefaultConfig.
+func defaultRetrySettings() RetrySettings {
+	defaults := occretry.DefaultConfig()
+	return RetrySettings{
+		MaxRetries:  defaults.MaxRetries,
+		InitialWait: defaults.InitialWait,
+		MaxWait:     defaults.MaxWait,
+		Multiplier:  defaults.Multiplier,
+	}
+}
+
+// Config returns the settings as an occretry.Config.
+func (s *RetrySettings) Config() occretry.Config {
+	return occretry.Config{
+		MaxRetries:  s.MaxRetries,
+		InitialWait: s.InitialWait,
+		MaxWait:     s.MaxWait,
+		Multiplier:  s.Multiplier,
+	}
+}
+
+// RetryConfig returns the OCC retry config of f, or occretry.DefaultConfig if
+// f.Retry is nil.
+func (f *FileConfig) RetryConfig() occretry.Config {
+	if f.Retry == nil {
+		return occretry.DefaultConfig()
+	}
+	return f.Retry.Config()
+}
+
+// PoolSettings are pgxpool settings. Zero fields keep the defaults of NewPool.
+type PoolSettings struct {
+	MaxConns              int32         `json:"maxConns,omitempty" yaml:"maxConns,omitempty" toml:"maxConns,omitempty"`
+	MinConns              int32         `json:"minConns,omitempty" yaml:"minConns,omitempty" toml:"minConns,omitempty"`
+	MinIdleConns          int32         `json:"minIdleConns,omitempty" yaml:"minIdleConns,omitempty" toml:"minIdleConns,omitempty"`
+	MaxConnLifetime       time.Duration `json:"maxConnLifetime,omitempty" yaml:"maxConnLifetime,omitempty" toml:"maxConnLifetime,omitempty"`
+	MaxConnLifetimeJitter time.Duration `json:"maxConnLifetimeJitter,omitempty" yaml:"maxConnLifetimeJitter,omitempty" toml:"maxConnLifetimeJitter,omitempty"`
+	MaxConnIdleTime       time.Duration `json:"maxConnIdleTime,omitempty" yaml:"maxConnIdleTime,omitempty" toml:"maxConnIdleTime,omitempty"`
+	HealthCheckPeriod     time.Duration `json:"healthCheckPeriod,omitempty" yaml:"healthCheckPeriod,omitempty" toml:"healthCheckPeriod,omitempty"`
+}
+
+// Apply sets the non-zero settings of s on poolConfig.
+func (s *PoolSettings) Apply(poolConfig *pgxpool.Config) {
+	if s.MaxConns != 0 {
+		poolConfig.MaxConns = s.MaxConns
+	}
+	if s.MinConns != 0 {
+		poolConfig.MinConns = s.MinConns
+	}
+	if s.MinIdleConns != 0 {
+		poolConfig.MinIdleConns = s.MinIdleConns
+	}
+	if s.MaxConnLifetime != 0 {
+		poolConfig.MaxConnLifetime = s.MaxConnLifetime
+	}
+	if s.MaxConnLifetimeJitter != 0 {
+		poolConfig.MaxConnLifetimeJitter = s.MaxConnLifetimeJitter
+	}
+	if s.MaxConnIdleTime != 0 {
+		poolConfig.MaxConnIdleTime = s.MaxConnIdleTime
+	}
+	if s.HealthCheckPeriod != 0 {
+		poolConfig.HealthCheckPeriod = s.HealthCheckPeriod
+	}
+}
+
+// validate checks s, reporting fields as Pool.<name>.
+func (s *PoolSettings) validate() error {
+	var errs []error
+	check := func(field string, value int64) {
+		if value < 0 {
+			errs = append(errs, &FieldError{Field: "Pool." + field, Err: configErrorf("must not be negative, got %d", value)})
+		}
+	}
+	check("MaxConns", int64(s.MaxConns))
+	check("MinConns", int64(s.MinConns))
+	check("MinIdleConns", int64(s.MinIdleConns))
+	check("MaxConnLifetime", int64(s.MaxConnLifetime))
+	check("MaxConnLifetimeJitter", int64(s.MaxConnLifetimeJitter))
+	check("MaxConnIdleTime", int64(s.MaxConnIdleTime))
+	check("HealthCheckPeriod", int64(s.HealthCheckPeriod))
+
+	if s.MaxConns > 0 && s.MinConns > s.MaxConns {
+		errs = append(errs, &FieldError{Field: "Pool.MinConns", Err: configErrorf("must not exceed MaxConns (%d), got %d", s.MaxConns, s.MinConns)})
+	}
+	if s.MaxConns > 0 && s.MinIdleConns > s.MaxConns {
+		errs = append(errs, &FieldError{Field: "Pool.MinIdleConns", Err: configErrorf("must not exceed MaxConns (%d), got %d", s.MaxConns, s.MinIdleConns)})
+	}
+	return errors.Join(errs...)
+}
+
+// PoolConfig returns a pool config for NewPool with the settings of f.Pool, or
+// nil if f.Pool is nil. Connection lifetimes not set default to
+// DefaultMaxConnLifetime and DefaultMaxConnIdleTime, as for NewPool.
+//
+// The pgx and pgxpool parameters of f.DSQL.Params, such as connect_timeout,
+// are applied to the pool config, so they must be left out of the Config it is
+// used with, as FileConfig.NewPool does. Settings that pgxpool.ParseConfig reads
+// from PG* environment variables are not applied.
+func (f *FileConfig) PoolConfig() (*pgxpool.Config, error) {
+	if f.Pool == nil {
+		return nil, nil
+	}
+	poolConfig, err := pgxpool.ParseConfig(paramsDSN(f.DSQL.Params, true))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create pool config: %w", err)
+	}
+	clearEnvSettings(poolConfig.ConnConfig, f.DSQL.Params)
+
+	if _, ok := f.DSQL.Params["pool_max_conn_lifetime"]; !ok {
+		poolConfig.MaxConnLifetime = DefaultMaxConnLifetime
+	}
+	if _, ok := f.DSQL.Params["pool_max_conn_idle_time"]; !ok {
+		poolConfig.MaxConnIdleTime = DefaultMaxConnIdleTime
+	}
+	f.Pool.Apply(poolConfig)
+	return poolConfig, nil
+}
+
+// NewPool creates a connection pool with the settings of f.
+func (f *FileConfig) NewPool(ctx context.Context) (*pgxpool.Pool, error) {
+	poolConfig, err := f.PoolConfig()
+	if err != nil {
+		return nil, err
+	}
+	if poolConfig == nil {
+		return NewPool(ctx, f.DSQL)
+	}
+
+	// The pgx and pgxpool parameters were applied to poolConfig.
+	cfg := f.DSQL
+	cfg.Params = make(map[string]string, len(f.DSQL.Params))
+	for key, value := range f.DSQL.Params {
+		if !pgxParams[key] && !poolParams[key] {
+			cfg.Params[key] = value
+		}
+	}
+	return NewPool(ctx, cfg, poolConfig)
+}
+
+// validateRetry checks OCC retry settings, reporting fields as Retry.<name>.
+func validateRetry(c *RetrySettings) error {
+	var errs []error
+	check := func(field string, err error) {
+		errs = append(errs, &FieldError{Field: "Retry." + field, Err: err})
+	}
+	if c.MaxRetries < 0 {
+		check("MaxRetries", configErrorf("must not be negative, got %d", c.MaxRetries))
+	}
+	if c.InitialWait <= 0 {
+		check("InitialWait", configErrorf("must be positive, got %s", c.InitialWait))
+	}
+	if c.MaxWait < c.InitialWait {
+		check("MaxWait", configErrorf("must be at least InitialWait (%s), got %s", c.InitialWait, c.MaxWait))
+	}
+	if c.Multiplier < 1 {
+		check("Multiplier", configErrorf("must be at least 1, got %g", c.Multiplier))
+	}
+	return errors.Join(errs...)
+}
+
+// LoadConfigFile loads the settings of environment env from a YAML (.yaml or
+// .yml), JSON (.json) or TOML (.toml) file.
+//
+// The top-level keys of the file name environments, each holding dsql, pool
+// and retry sections with the keys of the struct tags of FileConfig. Settings
+// in the DefaultEnvironment section apply to every environment, which may
+// override them key by key. If env is empty, the whole file is one environment.
+//
+//	default:
+//	  dsql:
+//	    user: app
+//	  retry:
+//	    maxRetries: 5
+//	prod:
+//	  dsql:
+//	    host: ${PROD_CLUSTER_ID}
+//	    region: us-east-1
+//	  pool:
+//	    maxConns: 50
+//	    maxConnIdleTime: 5m
+//
+// String values may reference environment variables as ${NAME}, or as
+// ${NAME:-default} to use default when NAME is unset or empty; $$ is a literal
+// $. A value that is entirely interpolated is typed as if written in the file,
+// so port: ${PORT} is a number. Durations are strings such as "30s". Unknown
+// keys are errors.
+//
+// The settings are validated with the same rules as NewPool, and the returned
+// error lists every problem found, as *FieldError values with fields prefixed
+// by DSQL., Pool. or Retry. The error matches ErrInvalidConfig.
+func LoadConfigFile(path, env string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, configErrorf("unable to read config file: %w", err)
+	}
+
+	doc, err := parseConfigDocument(data, strings.ToLower(filepath.Ext(path)))
+	if err != nil {
+		return nil, configErrorf("config file %s: %w", path, err)
+	}
+
+	settings, err := selectEnvironment(doc, env)
+	if err != nil {
+		return nil, configErrorf("config file %s: %w", path, err)
+	}
+
+	node, err := toYAMLNode(settings)
+	if err != nil {
+		return nil, configErrorf("config file %s: %w", path, err)
+	}
+
+	fileConfig, err := decodeFileConfig(node, settings)
+	if err != nil {
+		return nil, configErrorf("config file %s: %w", path, err)
+	}
+
+	if err := fileConfig.validate(); err != nil {
+		if env != "" {
+			return nil, fmt.Errorf("config file %s, environment %q: %w", path, env, err)
+		}
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return fileConfig, nil
+}
+
+// parseConfigDocument parses data in the format given by a file extension into
+// a generic tree of maps, slices and scalars.
+func parseConfigDocument(data []byte, ext string) (map[string]any, error) {
+	doc := make(map[string]any)
+	var err error
+	switch ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("unsupported format %q: must be .yaml, .yml, .json or .toml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// selectEnvironment returns the settings of env merged over the default
+// environment, or doc itself if env is empty.
+func selectEnvironment(doc map[string]any, env string) (map[string]any, error) {
+	if env == "" {
+		return doc, nil
+	}
+
+	section, ok := doc[env]
+	if !ok || env == DefaultEnvironment {
+		var names []string
+		for name := range doc {
+			if name != DefaultEnvironment {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("environment %q not found, have %s", env, strings.Join(names, ", "))
+	}
+	settings, ok := section.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("environment %q is not a table of settings", env)
+	}
+
+	if defaults, ok := doc[DefaultEnvironment]; ok {
+		defaultSettings, ok := defaults.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("environment %q is not a table of settings", DefaultEnvironment)
+		}
+		settings = mergeSettings(defaultSettings, settings)
+	}
+	return settings, nil
+}
+
+// mergeSettings returns override merged over base: tables are merged key by
+// key, and other values replaced.
+func mergeSettings(base, override map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		baseTable, baseOK := merged[key].(map[string]any)
+		table, ok := value.(map[string]any)
+		if baseOK && ok {
+			value = mergeSettings(baseTable, table)
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// envRefPattern matches $$, ${NAME} and ${NAME:-default}.
+var envRefPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// interpolate replaces environment variable references in s, and reports
+// whether s was entirely one reference.
+func interpolate(s string) (string, bool, error) {
+	var err error
+	expanded := envRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		match := envRefPattern.FindStringSubmatch(ref)
+		if value := os.Getenv(match[1]); value != "" {
+			return value
+		}
+		if strings.Contains(ref, ":-") {
+			return match[2]
+		}
+		if _, ok := os.LookupEnv(match[1]); !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", match[1])
+		}
+		return ""
+	})
+	whole := envRefPattern.FindString(s) == s && s != "$$"
+	return expanded, whole, err
+}
+
+// toYAMLNode converts a generic tree to a YAML node, interpolating environment
+// variables in strings. Strings that were entirely a reference are left
+// untagged so that they are typed by their value, as in a YAML document.
+func toYAMLNode(value any) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	case string:
+		expanded, whole, err := interpolate(v)
+		if err != nil {
+			return nil, err
+		}
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: expanded}
+		if whole {
+			node.Tag = ""
+		}
+		return node, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}, nil
+	case time.Time:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!timestamp", Value: v.Format(time.RFC3339Nano)}, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(rv.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatUint(rv.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		// JSON numbers are float64: keep integral values integers.
+		f := rv.Float()
+		if f == float64(int64(f)) {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(int64(f), 10)}, nil
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(f, 'g', -1, 64)}, nil
+	case reflect.Slice, reflect.Array:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for i := range rv.Len() {
+			item, err := toYAMLNode(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, item)
+		}
+		return node, nil
+	case reflect.Map:
+		keys := make([]string, 0, rv.Len())
+		for _, key := range rv.MapKeys() {
+			keys = append(keys, fmt.Sprint(key.Interface()))
+		}
+		slices.Sort(keys)
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range keys {
+			item, err := toYAMLNode(rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, item)
+		}
+		return node, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", value, value)
+}
+
+// decodeFileConfig decodes node into a FileConfig, rejecting unknown keys.
+func decodeFileConfig(node *yaml.Node, settings map[string]any) (*FileConfig, error) {
+	fileConfig := &FileConfig{}
+	if _, ok := settings["retry"]; ok {
+		defaults := defaultRetrySettings()
+		fileConfig.Retry = &defaults
+	}
+
+	// Decoding from a document, rather than the node, checks for unknown keys.
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(fileConfig); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, err
+		}
+		// The line numbers are of the generated document: report keys instead.
+		var doc yaml.Node
+		if yaml.Unmarshal(data, &doc) != nil {
+			return nil, err
+		}
+		paths := make(map[int]string)
+		keyPaths(&doc, "", paths)
+		var errs []error
+		for _, msg := range typeErr.Errors {
+			if match := yamlLinePattern.FindStringSubmatch(msg); match != nil {
+				line, _ := strconv.Atoi(match[1])
+				msg = paths[line] + ": " + msg[len(match[0]):]
+			}
+			errs = append(errs, errors.New(msg))
+		}
+		return nil, errors.Join(errs...)
+	}
+	return fileConfig, nil
+}
+
+// yamlLinePattern matches the line number prefix of a yaml.TypeError message.
+var yamlLinePattern = regexp.MustCompile(`^line (\d+): `)
+
+// keyPaths records the dotted key path of each line of a block-style document.
+func keyPaths(node *yaml.Node, path string, paths map[int]string) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, item := range node.Content {
+			keyPaths(item, path, paths)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			paths[item.Line] = itemPath
+			keyPaths(item, itemPath, paths)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyPath := node.Content[i].Value
+			if path != "" {
+				keyPath = path + "." + keyPath
+			}
+			paths[node.Content[i].Line] = keyPath
+			keyPaths(node.Content[i+1], keyPath, paths)
+		}
+	}
+}
+
+// validate checks f with the rules of NewPool.
+func (f *FileConfig) validate() error {
+	var errs []error
+	if _, err := f.DSQL.resolve(); err != nil {
+		errs = append(errs, prefixFieldErrors("DSQL.", err))
+	}
+	if f.Pool != nil {
+		if err := f.Pool.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if f.Retry != nil {
+		if err := validateRetry(f.Retry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// prefixFieldErrors returns err with prefix added to the field of each
+// FieldError it joins.
+func prefixFieldErrors(prefix string, err error) error {
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) && fieldErr == err {
+		return &FieldError{Field: prefix + fieldErr.Field, Err: fieldErr.Err}
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return err
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, prefixFieldErrors(prefix, e))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,419 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/awslabs/aurora-dsql-connectors/go/pgx/occretry"
+)
+
+// writeConfigFile writes content to a file with the given name in a temporary
+// directory and returns its path.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfigFileFormats(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+default:
+  dsql:
+    user: app
+    region: us-east-1
+  retry:
+    maxRetries: 5
+dev:
+  dsql:
+    host: ${DSQL_TEST_CLUSTER_ID}
+    port: ${DSQL_TEST_PORT:-5433}
+    tls:
+      mode: verify-ca
+staging:
+  dsql:
+    host: staging.example.com
+prod:
+  dsql:
+    host: ijsamhssbh36dopuigphknejb4
+    region: us-west-2
+    assumeRole:
+      - roleArn: arn:aws:iam::123456789012:role/app
+        sessionTags:
+          team: data
+  pool:
+    maxConns: 50
+    maxConnIdleTime: 5m
+  retry:
+    initialWait: 50ms
+`,
+		"config.json": `{
+  "default": {
+    "dsql": {"user": "app", "region": "us-east-1"},
+    "retry": {"maxRetries": 5}
+  },
+  "dev": {
+    "dsql": {"host": "${DSQL_TEST_CLUSTER_ID}", "port": "${DSQL_TEST_PORT:-5433}", "tls": {"mode": "verify-ca"}}
+  },
+  "prod": {
+    "dsql": {
+      "host": "ijsamhssbh36dopuigphknejb4",
+      "region": "us-west-2",
+      "assumeRole": [{"roleArn": "arn:aws:iam::123456789012:role/app", "sessionTags": {"team": "data"}}]
+    },
+    "pool": {"maxConns": 50, "maxConnIdleTime": "5m"},
+    "retry": {"initialWait": "50ms"}
+  }
+}`,
+		"config.toml": `
+[default.dsql]
+user = "app"
+region = "us-east-1"
+
+[default.retry]
+maxRetries = 5
+
+[dev.dsql]
+host = "${DSQL_TEST_CLUSTER_ID}"
+port = "${DSQL_TEST_PORT:-5433}"
+tls = { mode = "verify-ca" }
+
+[prod.dsql]
+host = "ijsamhssbh36dopuigphknejb4"
+region = "us-west-2"
+
+[[prod.dsql.assumeRole]]
+roleArn = "arn:aws:iam::123456789012:role/app"
+sessionTags = { team = "data" }
+
+[prod.pool]
+maxConns = 50
+maxConnIdleTime = "5m"
+
+[prod.retry]
+initialWait = "50ms"
+`,
+	}
+
+	t.Setenv("DSQL_TEST_CLUSTER_ID", "ijsamhssbh36dopuigphknejb4")
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfigFile(t, name, content)
+
+			dev, err := LoadConfigFile(path, "dev")
+			require.NoError(t, err)
+			assert.Equal(t, Config{
+				Host:   "ijsamhssbh36dopuigphknejb4",
+				Region: "us-east-1",
+				User:   "app",
+				Port:   5433,
+				TLS:    &TLSConfig{Mode: "verify-ca"},
+			}, dev.DSQL)
+			assert.Nil(t, dev.Pool)
+			assert.Equal(t, &RetrySettings{
+				MaxRetries:  5,
+				InitialWait: 100 * time.Millisecond,
+				MaxWait:     5 * time.Second,
+				Multiplier:  2,
+			}, dev.Retry)
+			assert.Equal(t, occretry.DefaultConfig().MaxWait, dev.RetryConfig().MaxWait)
+
+			prod, err := LoadConfigFile(path, "prod")
+			require.NoError(t, err)
+			assert.Equal(t, "us-west-2", prod.DSQL.Region)
+			assert.Equal(t, "app", prod.DSQL.User)
+			assert.Equal(t, []AssumeRoleOptions{{
+				RoleARN:     "arn:aws:iam::123456789012:role/app",
+				SessionTags: map[string]string{"team": "data"},
+			}}, prod.DSQL.AssumeRole)
+			assert.Equal(t, &PoolSettings{MaxConns: 50, MaxConnIdleTime: 5 * time.Minute}, prod.Pool)
+			assert.Equal(t, 5, prod.Retry.MaxRetries)
+			assert.Equal(t, 50*time.Millisecond, prod.Retry.InitialWait)
+		})
+	}
+}
+
+func TestLoadConfigFileWholeDocument(t *testing.T) {
+	path := writeConfigFile(t, "config.yml", `
+dsql:
+  host: ijsamhssbh36dopuigphknejb4.dsql.us-east-1.on.aws
+  params:
+    application_name: "$${literal}"
+`)
+	cfg, err := LoadConfigFile(path, "")
+	require.NoError(t, err)
+	assert.Equal(t, "ijsamhssbh36dopuigphknejb4.dsql.us-east-1.on.aws", cfg.DSQL.Host)
+	assert.Equal(t, map[string]string{"application_name": "${literal}"}, cfg.DSQL.Params)
+	assert.Nil(t, cfg.Pool)
+	assert.Nil(t, cfg.Retry)
+	assert.Equal(t, occretry.DefaultConfig(), cfg.RetryConfig())
+}
+
+func TestLoadConfigFileInterpolation(t *testing.T) {
+	t.Setenv("DSQL_TEST_REGION", "us-west-2")
+	t.Setenv("DSQL_TEST_EMPTY", "")
+
+	tests := []struct {
+		value string
+		want  string
+		err   string
+	}{
+		{value: "${DSQL_TEST_REGION}", want: "us-west-2"},
+		{value: "prefix-${DSQL_TEST_REGION}-suffix", want: "prefix-us-west-2-suffix"},
+		{value: "${DSQL_TEST_UNSET:-fallback}", want: "fallback"},
+		{value: "${DSQL_TEST_EMPTY:-fallback}", want: "fallback"},
+		{value: "${DSQL_TEST_EMPTY}", want: ""},
+		{value: "$$DSQL_TEST_REGION", want: "$DSQL_TEST_REGION"},
+		{value: "$DSQL_TEST_REGION", want: "$DSQL_TEST_REGION"},
+		{value: "${DSQL_TEST_UNSET}", err: "environment variable DSQL_TEST_UNSET is not set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, _, err := interpolate(tt.value)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	path := writeConfigFile(t, "config.yaml", `
+dsql:
+  host: ijsamhssbh36dopuigphknejb4
+  region: ${DSQL_TEST_UNSET}
+`)
+	_, err := LoadConfigFile(path, "")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "DSQL_TEST_UNSET is not set")
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     string
+		errors  []string
+		fields  []string
+	}{
+		{
+			name:    "unsupported format",
+			file:    "config.ini",
+			content: "host = x",
+			errors:  []string{`unsupported format ".ini"`},
+		},
+		{
+			name:    "syntax error",
+			file:    "config.json",
+			content: `{"dsql": `,
+			errors:  []string{"unexpected end of JSON input"},
+		},
+		{
+			name:    "unknown environment",
+			file:    "config.yaml",
+			content: "default: {}\ndev: {}\nprod: {}\n",
+			env:     "staging",
+			errors:  []string{`environment "staging" not found, have dev, prod`},
+		},
+		{
+			name:    "unknown key",
+			file:    "config.yaml",
+			content: "dsql:\n  host: ijsamhssbh36dopuigphknejb4\n  region: us-east-1\n  hots: x\n",
+			errors:  []string{"dsql.hots: field hots not found"},
+		},
+		{
+			name:    "wrong type",
+			file:    "config.toml",
+			content: "[dsql]\nhost = \"ijsamhssbh36dopuigphknejb4\"\nport = \"abc\"\n",
+			errors:  []string{"dsql.port: cannot unmarshal"},
+		},
+		{
+			name:    "duration without unit",
+			file:    "config.json",
+			content: `{"dsql": {"host": "` + testHost + `"}, "pool": {"maxConnIdleTime": "5"}}`,
+			errors:  []string{"pool.maxConnIdleTime: cannot unmarshal"},
+		},
+		{
+			name: "invalid settings",
+			file: "config.yaml",
+			content: `
+prod:
+  dsql:
+    host: ijsamhssbh36dopuigphknejb4
+    port: 70000
+  pool:
+    maxConns: 4
+    minConns: 8
+  retry:
+    multiplier: 0.5
+`,
+			env:    "prod",
+			errors: []string{`environment "prod"`},
+			fields: []string{"DSQL.Region", "DSQL.Port", "Pool.MinConns", "Retry.Multiplier"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFile(writeConfigFile(t, tt.file, tt.content), tt.env)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+			for _, want := range tt.errors {
+				assert.ErrorContains(t, err, want)
+			}
+
+			fields := fieldErrorNames(err)
+			for _, want := range tt.fields {
+				assert.Contains(t, fields, want)
+			}
+		})
+	}
+
+	_, err := LoadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"), "")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// fieldErrorNames returns the fields of the FieldErrors wrapped or joined by
+// err.
+func fieldErrorNames(err error) []string {
+	if fieldErr, ok := err.(*FieldError); ok {
+		return []string{fieldErr.Field}
+	}
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		var fields []string
+		for _, joined := range e.Unwrap() {
+			fields = append(fields, fieldErrorNames(joined)...)
+		}
+		return fields
+	case interface{ Unwrap() error }:
+		return fieldErrorNames(e.Unwrap())
+	}
+	return nil
+}
+
+func TestFileConfigPoolConfig(t *testing.T) {
+	cfg := &FileConfig{}
+	poolConfig, err := cfg.PoolConfig()
+	require.NoError(t, err)
+	assert.Nil(t, poolConfig)
+
+	cfg.Pool = &PoolSettings{MaxConns: 20, MinConns: 2, HealthCheckPeriod: 30 * time.Second}
+	poolConfig, err = cfg.PoolConfig()
+	require.NoError(t, err)
+	assert.Equal(t, int32(20), poolConfig.MaxConns)
+	assert.Equal(t, int32(2), poolConfig.MinConns)
+	assert.Equal(t, 30*time.Second, poolConfig.HealthCheckPeriod)
+	assert.Equal(t, DefaultMaxConnLifetime, poolConfig.MaxConnLifetime)
+	assert.Equal(t, DefaultMaxConnIdleTime, poolConfig.MaxConnIdleTime)
+
+	// PG* environment variables are ignored, and connection parameters applied.
+	t.Setenv("PGAPPNAME", "from-env")
+	t.Setenv("PGCONNECT_TIMEOUT", "9")
+	t.Setenv("PGTARGETSESSIONATTRS", "read-write")
+	poolConfig, err = cfg.PoolConfig()
+	require.NoError(t, err)
+	assert.Empty(t, poolConfig.ConnConfig.RuntimeParams)
+	assert.Zero(t, poolConfig.ConnConfig.ConnectTimeout)
+	assert.Nil(t, poolConfig.ConnConfig.ValidateConnect)
+
+	cfg.DSQL.Params = map[string]string{"connect_timeout": "5", "pool_max_conn_idle_time": "1m"}
+	poolConfig, err = cfg.PoolConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, poolConfig.ConnConfig.ConnectTimeout)
+	assert.Equal(t, time.Minute, poolConfig.MaxConnIdleTime)
+}
+
+func TestFileConfigNewPool(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+dsql:
+  host: ijsamhssbh36dopuigphknejb4.dsql.us-east-1.on.aws
+  params:
+    connect_timeout: "5"
+    search_path: app
+pool:
+  maxConns: 7
+`)
+	fc, err := LoadConfigFile(path, "")
+	require.NoError(t, err)
+	fc.DSQL.TokenProvider = &staticTokenProvider{token: "t"}
+
+	pool, err := fc.NewPool(context.Background())
+	require.NoError(t, err)
+	defer pool.Close()
+	config := pool.Config()
+	assert.Equal(t, int32(7), config.MaxConns)
+	assert.Equal(t, 5*time.Second, config.ConnConfig.ConnectTimeout)
+	assert.Equal(t, "app", config.ConnConfig.RuntimeParams["search_path"])
+	assert.Equal(t, map[string]string{"connect_timeout": "5", "search_path": "app"}, fc.DSQL.Params)
+
+	fc.Pool = nil
+	pool, err = fc.NewPool(context.Background())
+	require.NoError(t, err)
+	defer pool.Close()
+	assert.Equal(t, 5*time.Second, pool.Config().ConnConfig.ConnectTimeout)
+}
+
+func TestConfigStructTags(t *testing.T) {
+	// The types can be embedded in application config.
+	type appConfig struct {
+		Name     string        `json:"name" yaml:"name"`
+		Database Config        `json:"database" yaml:"database"`
+		Retry    RetrySettings `json:"retry" yaml:"retry"`
+	}
+	want := appConfig{
+		Name: "app",
+		Database: Config{
+			Host:       testHost,
+			User:       "app",
+			AssumeRole: []AssumeRoleOptions{{RoleARN: "arn:aws:iam::123456789012:role/app"}},
+			TLS:        &TLSConfig{Mode: "verify-full", AmazonRoots: true},
+		},
+		Retry: RetrySettings{MaxRetries: 2, MaxWait: 3 * time.Second, Multiplier: 1.5},
+	}
+
+	data, err := json.Marshal(want)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"host":"`+testHost+`"`)
+	assert.Contains(t, string(data), `"roleArn":"arn:aws:iam::123456789012:role/app"`)
+	assert.NotContains(t, string(data), "Dialer")
+	// encoding/json represents durations as nanoseconds.
+	assert.Contains(t, string(data), `"maxWait":3000000000`)
+
+	var fromJSON appConfig
+	require.NoError(t, json.Unmarshal(data, &fromJSON))
+	assert.Equal(t, want, fromJSON)
+
+	data, err = yaml.Marshal(want)
+	require.NoError(t, err)
+	var fromYAML appConfig
+	require.NoError(t, yaml.Unmarshal(data, &fromYAML))
+	assert.Equal(t, want, fromYAML)
+
+	var fromTOML appConfig
+	_, err = toml.Decode("name = \"app\"\n[retry]\nmaxWait = \"3s\"\n", &fromTOML)
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, fromTOML.Retry.MaxWait)
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redacted replaces the values of secret-bearing fields in Config.String.
+const redacted = "REDACTED"
+
+// ConnectionString returns c as a canonical dsql:// URL, with the host and
+// region resolved as they would be by NewPool and Connect.
+//
+// ParseConnectionString of the result returns a Config equal to c, except that
+// Host and Region hold their resolved values and TLS.Mode, TLS.RootCertFile and
+// TLS.AmazonRoots are returned as the sslmode and sslrootcert parameters in
+// Params. Fields that cannot be expressed in a connection string are omitted:
+// CustomCredentialsProvider, StaticCredentials, TokenProvider, TimeSource,
+// OnClockSkew, Dialer, RuntimeParams, TLS.RootCAs, TLS.PinnedPublicKeys and
+// TLS.MinVersion, along with the static and custom credential sources that
+// depend on them.
+func (c Config) ConnectionString() (string, error) {
+	resolved, err := c.resolve()
+	if err != nil {
+		return "", err
+	}
+	return c.connectionURL(resolved.Host, resolved.Region, false).String(), nil
+}
+
+// String returns c as a dsql:// URL for logging and diffing. Secret-bearing
+// fields are redacted. The host and region are resolved if c is valid.
+func (c Config) String() string {
+	host, region := c.Host, c.Region
+	if resolved, err := c.resolve(); err == nil {
+		host, region = resolved.Host, resolved.Region
+	}
+	return c.connectionURL(host, region, true).String()
+}
+
+// connectionURL renders c as a dsql:// URL with the given host and region. If
+// display is set, fields that cannot be round-tripped are included, with their
+// values redacted where they may hold secrets.
+func (c *Config) connectionURL(host, region string, display bool) *url.URL {
+	query := make(url.Values)
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	set("region", region)
+	set("profile", c.Profile)
+	if c.TokenDurationSecs != 0 {
+		set("tokenDurationSecs", strconv.Itoa(c.TokenDurationSecs))
+	}
+	if c.TokenRefreshFraction != 0 {
+		set("tokenRefreshFraction", strconv.FormatFloat(c.TokenRefreshFraction, 'g', -1, 64))
+	}
+	if c.MaxAuthRetries != 0 {
+		set("maxAuthRetries", strconv.Itoa(c.MaxAuthRetries))
+	}
+	if c.DisableAuthRetry {
+		set("disableAuthRetry", "true")
+	}
+	if display || (c.CredentialSource != CredentialSourceStatic && c.CredentialSource != CredentialSourceCustom) {
+		set("credentialSource", string(c.CredentialSource))
+	}
+	set("webIdentityTokenFile", c.WebIdentityTokenFile)
+	set("webIdentityRoleArn", c.WebIdentityRoleARN)
+	set("tokenAction", string(c.TokenAction))
+	if c.ClockSkewCompensation {
+		set("clockSkewCompensation", "true")
+	}
+	set("serviceName", c.ServiceName)
+	set("dialHost", c.DialHost)
+	set("endpointVariant", string(c.EndpointVariant))
+	addAssumeRoleParams(query, c.AssumeRole)
+	for key, value := range c.Params {
+		query.Set(key, value)
+	}
+	if c.TLS != nil {
+		set("sslmode", c.TLS.Mode)
+		set("sslrootcert", c.TLS.RootCertFile)
+		if c.TLS.AmazonRoots {
+			set("sslrootcert", RootCertAmazon)
+		}
+	}
+
+	if display {
+		if c.StaticCredentials != nil {
+			set("staticCredentials", redacted)
+		}
+		if c.CustomCredentialsProvider != nil {
+			set("customCredentialsProvider", redacted)
+		}
+		if c.TokenProvider != nil {
+			set("tokenProvider", redacted)
+		}
+		if c.TimeSource != nil {
+			set("timeSource", redacted)
+		}
+		for key, value := range c.RuntimeParams {
+			set("runtimeParams."+key, value)
+		}
+		if c.Dialer != nil {
+			set("dialer", redacted)
+		}
+		if c.TLS != nil && c.TLS.RootCAs != nil {
+			set("tlsRootCAs", redacted)
+		}
+		if c.TLS != nil && len(c.TLS.PinnedPublicKeys) > 0 {
+			set("tlsPinnedPublicKeys", strings.Join(c.TLS.PinnedPublicKeys, ","))
+		}
+	}
+
+	// An unresolved cluster ARN is not a valid URL host.
+	if IsClusterARN(host) {
+		set("host", host)
+		host = ""
+	}
+
+	u := &url.URL{
+		Scheme:   "dsql",
+		Host:     host,
+		RawQuery: query.Encode(),
+	}
+	if c.Port != 0 {
+		u.Host = net.JoinHostPort(host, strconv.Itoa(c.Port))
+	}
+	if c.User != "" {
+		u.User = url.User(c.User)
+	}
+	if c.Database != "" || u.Host == "" {
+		// The path keeps the authority in the URL when the host is empty.
+		u.Path = "/" + c.Database
+	}
+	return u
+}
+
+// addAssumeRoleParams adds the connection string parameters for an assume-role
+// chain, the inverse of parseAssumeRoleParams. An option set on any hop is given
+// for every hop, with empty values for hops that leave it unset, so that the
+// values stay aligned with their roles.
+func addAssumeRoleParams(query url.Values, hops []AssumeRoleOptions) {
+	if len(hops) == 0 {
+		return
+	}
+
+	options := []struct {
+		param string
+		value func(AssumeRoleOptions) string
+	}{
+		{"assumeRoleExternalId", func(h AssumeRoleOptions) string { return h.ExternalID }},
+		{"assumeRoleSessionName", func(h AssumeRoleOptions) string { return h.SessionName }},
+		{"assumeRoleSessionTags", func(h AssumeRoleOptions) string { return formatSessionTags(h.SessionTags) }},
+		{"assumeRoleDurationSecs", func(h AssumeRoleOptions) string {
+			if h.Duration == 0 {
+				return ""
+			}
+			return strconv.Itoa(int(h.Duration / time.Second))
+		}},
+		{"assumeRoleSourceProfile", func(h AssumeRoleOptions) string { return h.SourceProfile }},
+	}
+
+	for _, hop := range hops {
+		query.Add("assumeRoleArn", hop.RoleARN)
+	}
+	for _, option := range options {
+		values := make([]string, len(hops))
+		used := false
+		for i, hop := range hops {
+			values[i] = option.value(hop)
+			used = used || values[i] != ""
+		}
+		if used {
+			query[option.param] = values
+		}
+	}
+}
+
+// formatSessionTags renders session tags as sorted key:value pairs separated by commas.
+func formatSessionTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+":"+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
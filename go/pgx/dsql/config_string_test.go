@@ -0,0 +1,103 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigConnectionStringRoundTrip(t *testing.T) {
+	cfg := Config{
+		Host:                  "mycluster.dsql.us-east-1.on.aws",
+		Region:                "us-east-1",
+		User:                  "app user",
+		Database:              "orders/db",
+		Port:                  5433,
+		Profile:               "dev",
+		TokenDurationSecs:     600,
+		TokenRefreshFraction:  0.5,
+		MaxAuthRetries:        4,
+		DisableAuthRetry:      true,
+		CredentialSource:      CredentialSourceProfile,
+		TokenAction:           TokenActionStandard,
+		ClockSkewCompensation: true,
+		ServiceName:           "orders-svc",
+		DialHost:              "vpce-0123456789abcdef0-abcdefgh.dsql-fnh4.us-east-1.vpce.amazonaws.com",
+		EndpointVariant:       EndpointFIPS,
+		AssumeRole: []AssumeRoleOptions{
+			{RoleARN: "arn:aws:iam::111111111111:role/A", SourceProfile: "dev", Duration: time.Hour},
+			{RoleARN: "arn:aws:iam::222222222222:role/B", ExternalID: "ext", SessionTags: map[string]string{"team": "orders", "env": "prod"}},
+		},
+		Params: map[string]string{"search_path": "app,public", "pool_max_conns": "7"},
+	}
+
+	connStr, err := cfg.ConnectionString()
+	require.NoError(t, err)
+	assert.Regexp(t, `^dsql://app%20user@mycluster\.dsql\.us-east-1\.on\.aws:5433/orders/db\?`, connStr)
+
+	parsed, err := ParseConnectionString(connStr)
+	require.NoError(t, err)
+	assert.Equal(t, cfg, *parsed)
+
+	// Serializing again is stable.
+	again, err := parsed.ConnectionString()
+	require.NoError(t, err)
+	assert.Equal(t, connStr, again)
+}
+
+func TestConfigConnectionStringResolves(t *testing.T) {
+	cfg := Config{Host: "ijsamhssbh36dopuigphknejb4", Region: "us-west-2"}
+
+	connStr, err := cfg.ConnectionString()
+	require.NoError(t, err)
+	assert.Equal(t, "dsql://ijsamhssbh36dopuigphknejb4.dsql.us-west-2.on.aws?region=us-west-2", connStr)
+
+	parsed, err := ParseConnectionString(connStr)
+	require.NoError(t, err)
+	assert.Equal(t, Config{Host: "ijsamhssbh36dopuigphknejb4.dsql.us-west-2.on.aws", Region: "us-west-2"}, *parsed)
+
+	_, err = Config{}.ConnectionString()
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestConfigConnectionStringOmitsSecrets(t *testing.T) {
+	cfg := Config{
+		Host:              "mycluster.dsql.us-east-1.on.aws",
+		CredentialSource:  CredentialSourceStatic,
+		StaticCredentials: &aws.Credentials{AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "topsecret"},
+	}
+
+	connStr, err := cfg.ConnectionString()
+	require.NoError(t, err)
+	assert.Equal(t, "dsql://mycluster.dsql.us-east-1.on.aws?region=us-east-1", connStr)
+
+	str := cfg.String()
+	assert.NotContains(t, str, "AKIAEXAMPLE")
+	assert.NotContains(t, str, "topsecret")
+	assert.Contains(t, str, "credentialSource=static")
+	assert.Contains(t, str, "staticCredentials=REDACTED")
+}
+
+func TestConfigString(t *testing.T) {
+	cfg := Config{
+		Host:                      "mycluster.dsql.us-east-1.on.aws",
+		CustomCredentialsProvider: aws.AnonymousCredentials{},
+		TokenProvider:             &staticTokenProvider{token: "secret-token"},
+	}
+	str := fmt.Sprint(cfg)
+	assert.Equal(t, "dsql://mycluster.dsql.us-east-1.on.aws?customCredentialsProvider=REDACTED&region=us-east-1&tokenProvider=REDACTED", str)
+	assert.Equal(t, str, fmt.Sprint(&cfg))
+
+	// Invalid configurations are rendered unresolved.
+	invalid := Config{Host: "ijsamhssbh36dopuigphknejb4", User: "admin"}
+	assert.Equal(t, "dsql://admin@ijsamhssbh36dopuigphknejb4", invalid.String())
+}
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -28,6 +29,56 @@ func TestConfigDefaults(t *testing.T) {
 	assert.Equal(t, "postgres", resolved.Database)
 	assert.Equal(t, 5432, resolved.Port)
 	assert.Equal(t, DefaultTokenDuration, resolved.TokenDuration)
+	assert.Equal(t, DefaultTokenRefreshFraction, resolved.TokenRefreshFraction)
+	assert.Equal(t, DefaultMaxAuthRetries, resolved.AuthRetries)
+}
+
+func TestConfigTokenAction(t *testing.T) {
+	cfg, err := ParseConnectionString("postgres://proxy@mycluster.dsql.us-east-1.on.aws/postgres?tokenAction=admin")
+	require.NoError(t, err)
+	assert.Equal(t, TokenActionAdmin, cfg.TokenAction)
+
+	resolved, err := cfg.resolve()
+	require.NoError(t, err)
+	assert.Equal(t, TokenActionAdmin, resolved.TokenAction)
+
+	_, err = (&Config{Host: "mycluster.dsql.us-east-1.on.aws", TokenAction: "root"}).resolve()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), `unknown token action "root"`)
+}
+
+func TestParseConnectionStringClockSkewCompensation(t *testing.T) {
+	cfg, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres?clockSkewCompensation=true")
+	require.NoError(t, err)
+	assert.True(t, cfg.ClockSkewCompensation)
+
+	_, err = ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres?clockSkewCompensation=maybe")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestParseConnectionStringAuthParams(t *testing.T) {
+	cfg, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres?tokenRefreshFraction=0.5&maxAuthRetries=3&disableAuthRetry=true")
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, cfg.TokenRefreshFraction)
+	assert.Equal(t, 3, cfg.MaxAuthRetries)
+	assert.True(t, cfg.DisableAuthRetry)
+
+	for _, query := range []string{"tokenRefreshFraction=half", "maxAuthRetries=x", "disableAuthRetry=maybe"} {
+		_, err = ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres?" + query)
+		assert.ErrorIs(t, err, ErrInvalidConfig, query)
+	}
+}
+
+func TestConfigAuthRetries(t *testing.T) {
+	resolved, err := (&Config{Host: "mycluster.dsql.us-east-1.on.aws", MaxAuthRetries: 5}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, 5, resolved.AuthRetries)
+
+	resolved, err = (&Config{Host: "mycluster.dsql.us-east-1.on.aws", MaxAuthRetries: 5, DisableAuthRetry: true}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, 0, resolved.AuthRetries)
 }
 
 func TestConfigDefaultTokenDuration(t *testing.T) {
@@ -189,6 +240,60 @@ func TestConfigResolve(t *testing.T) {
 			config:  Config{Host: "mycluster.dsql.us-east-1.on.aws", Port: 65535},
 			wantErr: false,
 		},
+		{
+			name:    "token refresh fraction in range",
+			config:  Config{Host: "mycluster.dsql.us-east-1.on.aws", TokenRefreshFraction: 0.5},
+			wantErr: false,
+		},
+		{
+			name:    "token refresh fraction too high",
+			config:  Config{Host: "mycluster.dsql.us-east-1.on.aws", TokenRefreshFraction: 1.5},
+			wantErr: true,
+			errMsg:  "token refresh fraction must be between 0 and 1",
+		},
+		{
+			name:    "token refresh fraction negative",
+			config:  Config{Host: "mycluster.dsql.us-east-1.on.aws", TokenRefreshFraction: -0.1},
+			wantErr: true,
+			errMsg:  "token refresh fraction must be between 0 and 1",
+		},
+		{
+			name: "assume role chain",
+			config: Config{Host: "mycluster.dsql.us-east-1.on.aws", AssumeRole: []AssumeRoleOptions{
+				{RoleARN: "arn:aws:iam::111111111111:role/Hop1", SourceProfile: "base", Duration: time.Hour},
+				{RoleARN: "arn:aws:iam::222222222222:role/Hop2", ExternalID: "ext"},
+			}},
+			wantErr: false,
+		},
+		{
+			name:    "assume role missing ARN",
+			config:  Config{Host: "mycluster.dsql.us-east-1.on.aws", AssumeRole: []AssumeRoleOptions{{ExternalID: "ext"}}},
+			wantErr: true,
+			errMsg:  "assume role 0: role ARN is required",
+		},
+		{
+			name: "assume role source profile on later hop",
+			config: Config{Host: "mycluster.dsql.us-east-1.on.aws", AssumeRole: []AssumeRoleOptions{
+				{RoleARN: "arn:aws:iam::111111111111:role/Hop1"},
+				{RoleARN: "arn:aws:iam::222222222222:role/Hop2", SourceProfile: "base"},
+			}},
+			wantErr: true,
+			errMsg:  "assume role 1: source profile is only valid on the first role",
+		},
+		{
+			name: "assume role duration too short",
+			config: Config{Host: "mycluster.dsql.us-east-1.on.aws", AssumeRole: []AssumeRoleOptions{
+				{RoleARN: "arn:aws:iam::111111111111:role/Hop1", Duration: time.Minute},
+			}},
+			wantErr: true,
+			errMsg:  "assume role 0: duration must be between",
+		},
+		{
+			name:    "max auth retries negative",
+			config:  Config{Host: "mycluster.dsql.us-east-1.on.aws", MaxAuthRetries: -1},
+			wantErr: true,
+			errMsg:  "max auth retries must not be negative",
+		},
 		{
 			name:   "cluster ID without region fails",
 			config: Config{Host: "ijsamhssbh36dopuigphknejb4"},
@@ -339,3 +444,318 @@ func TestParseConnectionString(t *testing.T) {
 		})
 	}
 }
+
+func TestParseConnectionStringAssumeRole(t *testing.T) {
+	connStr := "dsql://admin@mycluster.dsql.us-east-1.on.aws/postgres" +
+		"?assumeRoleArn=arn:aws:iam::111111111111:role/Hop1" +
+		"&assumeRoleArn=arn:aws:iam::222222222222:role/Hop2" +
+		"&assumeRoleSourceProfile=base" +
+		"&assumeRoleExternalId=&assumeRoleExternalId=ext-123" +
+		"&assumeRoleSessionName=orders" +
+		"&assumeRoleSessionTags=team:payments,env:prod" +
+		"&assumeRoleDurationSecs=3600"
+
+	cfg, err := ParseConnectionString(connStr)
+	require.NoError(t, err)
+
+	assert.Equal(t, []AssumeRoleOptions{
+		{
+			RoleARN:       "arn:aws:iam::111111111111:role/Hop1",
+			SessionName:   "orders",
+			SessionTags:   map[string]string{"team": "payments", "env": "prod"},
+			Duration:      time.Hour,
+			SourceProfile: "base",
+		},
+		{
+			RoleARN:    "arn:aws:iam::222222222222:role/Hop2",
+			ExternalID: "ext-123",
+		},
+	}, cfg.AssumeRole)
+}
+
+func TestParseConnectionStringAssumeRoleErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		errMsg string
+	}{
+		{
+			name:   "option without role",
+			query:  "assumeRoleExternalId=ext",
+			errMsg: "assumeRoleExternalId given 1 times but only 0 assumeRoleArn values",
+		},
+		{
+			name:   "invalid duration",
+			query:  "assumeRoleArn=arn:aws:iam::111111111111:role/Hop1&assumeRoleDurationSecs=abc",
+			errMsg: "invalid assumeRoleDurationSecs",
+		},
+		{
+			name:   "invalid session tags",
+			query:  "assumeRoleArn=arn:aws:iam::111111111111:role/Hop1&assumeRoleSessionTags=team",
+			errMsg: "invalid assumeRoleSessionTags",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres?" + tt.query)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestParseConnectionStringCredentialSource(t *testing.T) {
+	cfg, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres" +
+		"?credentialSource=webIdentity&webIdentityTokenFile=/var/run/secrets/token&webIdentityRoleArn=arn:aws:iam::111111111111:role/PodRole")
+	require.NoError(t, err)
+
+	assert.Equal(t, CredentialSourceWebIdentity, cfg.CredentialSource)
+	assert.Equal(t, "/var/run/secrets/token", cfg.WebIdentityTokenFile)
+	assert.Equal(t, "arn:aws:iam::111111111111:role/PodRole", cfg.WebIdentityRoleARN)
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	tests := []struct {
+		name   string
+		config Config
+		fields []string
+		errMsg string
+	}{
+		{
+			name:   "valid",
+			config: Config{Host: "mycluster.dsql.us-east-1.on.aws", TokenDurationSecs: 604800},
+		},
+		{
+			name:   "region with a longer partition prefix",
+			config: Config{Host: "mycluster.dsql.eusc-de-east-1.on.aws"},
+		},
+		{
+			name:   "blank host",
+			config: Config{Host: "  "},
+			fields: []string{"Host"},
+			errMsg: "Host: host is required",
+		},
+		{
+			name:   "token duration over one week",
+			config: Config{Host: "mycluster.dsql.us-east-1.on.aws", TokenDurationSecs: 604801},
+			fields: []string{"TokenDurationSecs"},
+			errMsg: "token duration must be at most 604800 seconds",
+		},
+		{
+			name:   "negative token duration",
+			config: Config{Host: "mycluster.dsql.us-east-1.on.aws", TokenDurationSecs: -1},
+			fields: []string{"TokenDurationSecs"},
+			errMsg: "token duration must not be negative",
+		},
+		{
+			name:   "blank user",
+			config: Config{Host: "mycluster.dsql.us-east-1.on.aws", User: " \t"},
+			fields: []string{"User"},
+			errMsg: "User: user must not be blank",
+		},
+		{
+			name:   "malformed region",
+			config: Config{Host: "mycluster.dsql.us-east-1.on.aws", Region: "US_EAST_1"},
+			fields: []string{"Region"},
+			errMsg: `malformed region "US_EAST_1"`,
+		},
+		{
+			name:   "malformed region without partition prefix",
+			config: Config{Host: "mycluster.dsql.us-east-1.on.aws", Region: "east-1"},
+			fields: []string{"Region"},
+			errMsg: `malformed region "east-1"`,
+		},
+		{
+			name:   "malformed region from hostname",
+			config: Config{Host: "mycluster.dsql.useast.on.aws"},
+			fields: []string{"Region"},
+			errMsg: `malformed region "useast"`,
+		},
+		{
+			name: "every problem reported",
+			config: Config{
+				Host:                 "ijsamhssbh36dopuigphknejb4",
+				Port:                 -5,
+				TokenRefreshFraction: -0.5,
+				MaxAuthRetries:       -1,
+				TokenAction:          "root",
+				CredentialSource:     CredentialSourceStatic,
+				AssumeRole:           []AssumeRoleOptions{{}, {RoleARN: "arn:aws:iam::111111111111:role/B", SourceProfile: "dev"}},
+			},
+			fields: []string{"Region", "Port", "TokenRefreshFraction", "TokenAction", "MaxAuthRetries", "AssumeRole[0]", "AssumeRole[1]", "CredentialSource"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.fields == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+			if tt.errMsg != "" {
+				assert.Contains(t, err.Error(), tt.errMsg)
+			}
+
+			var fields []string
+			for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+				var fieldErr *FieldError
+				require.ErrorAs(t, e, &fieldErr)
+				fields = append(fields, fieldErr.Field)
+			}
+			assert.Equal(t, tt.fields, fields)
+
+			// resolve, and so NewPool and Connect, fail with the same error.
+			_, resolveErr := tt.config.resolve()
+			assert.Equal(t, err.Error(), resolveErr.Error())
+		})
+	}
+}
+
+func TestConfigDialHost(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	const vpce = "vpce-0123456789abcdef0-abcdefgh.dsql-fnh4.us-west-2.vpce.amazonaws.com"
+
+	// The region is inferred from the VPC endpoint name.
+	resolved, err := (&Config{Host: "ijsamhssbh36dopuigphknejb4", DialHost: vpce}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "us-west-2", resolved.Region)
+	assert.Equal(t, "ijsamhssbh36dopuigphknejb4.dsql.us-west-2.on.aws", resolved.Host)
+	require.NoError(t, resolved.loadTLSConfig())
+
+	connConfig, err := pgx.ParseConfig("")
+	require.NoError(t, err)
+	resolved.configureConnConfig(connConfig)
+	assert.Equal(t, vpce, connConfig.Host)
+	assert.Equal(t, "ijsamhssbh36dopuigphknejb4.dsql.us-west-2.on.aws", connConfig.TLSConfig.ServerName)
+
+	// A dial host without a region leaves the region of the cluster hostname.
+	resolved, err = (&Config{Host: "mycluster.dsql.us-east-1.on.aws", DialHost: "10.0.0.12"}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "us-east-1", resolved.Region)
+
+	resolved, err = (&Config{Host: "mycluster.dsql.us-west-2.on.aws", DialHost: vpce}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "us-west-2", resolved.Region)
+
+	// The regions of the hostname and the VPC endpoint must agree.
+	_, err = (&Config{Host: "mycluster.dsql.us-east-1.on.aws", DialHost: vpce}).resolve()
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), `region "us-east-1" of host conflicts with region "us-west-2" of dial host`)
+
+	// An explicit region is used as given.
+	resolved, err = (&Config{Host: "mycluster.dsql.us-east-1.on.aws", DialHost: vpce, Region: "us-east-1"}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "us-east-1", resolved.Region)
+
+	cfg, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres?dialHost=" + vpce)
+	require.NoError(t, err)
+	assert.Equal(t, vpce, cfg.DialHost)
+
+	err = Config{Host: vpce}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is a VPC endpoint: set DialHost to it")
+}
+
+func TestConfigClusterARN(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	const arn = "arn:aws:dsql:eu-west-1:123456789012:cluster/ijsamhssbh36dopuigphknejb4"
+
+	resolved, err := (&Config{Host: arn}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "ijsamhssbh36dopuigphknejb4.dsql.eu-west-1.on.aws", resolved.Host)
+	assert.Equal(t, "eu-west-1", resolved.Region)
+
+	resolved, err = (&Config{Host: arn, Region: "eu-west-1"}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west-1", resolved.Region)
+
+	_, err = (&Config{Host: arn, Region: "us-east-1"}).resolve()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `region "us-east-1" conflicts with region "eu-west-1" of cluster ARN`)
+
+	_, err = (&Config{Host: "arn:aws-cn:dsql:cn-north-1:123456789012:cluster/ijsamhssbh36dopuigphknejb4"}).resolve()
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), `no DSQL endpoints are known in partition "aws-cn"`)
+
+	_, err = (&Config{Host: "arn:aws-cn:dsql:us-east-1:123456789012:cluster/ijsamhssbh36dopuigphknejb4"}).resolve()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `region "us-east-1" of cluster ARN is not in partition "aws-cn"`)
+
+	_, err = (&Config{Host: "arn:aws-iso:dsql:us-iso-east-1:123456789012:cluster/ijsamhssbh36dopuigphknejb4"}).resolve()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `partition "aws-iso" of cluster ARN is not supported`)
+
+	// An unresolvable ARN is rendered as the host parameter.
+	cfg, err := ParseConnectionString(Config{Host: arn, Region: "us-east-1"}.String())
+	require.NoError(t, err)
+	assert.Equal(t, arn, cfg.Host)
+
+	err = Config{Host: "arn:aws:dsql:eu-west-1:123456789012:cluster/mycluster"}.Validate()
+	var fieldErr *FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "Host", fieldErr.Field)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	for _, connStr := range []string{
+		arn,
+		"host=" + arn + " user=admin",
+		"postgres://admin@/postgres?host=" + arn,
+	} {
+		cfg, err := ParseConnectionString(connStr)
+		require.NoError(t, err, connStr)
+		assert.Equal(t, arn, cfg.Host, connStr)
+	}
+}
+
+func TestConfigEndpointVariant(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	resolved, err := (&Config{Host: "ijsamhssbh36dopuigphknejb4", Region: "us-east-1", EndpointVariant: EndpointFIPS}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "ijsamhssbh36dopuigphknejb4.dsql-fips.us-east-1.on.aws", resolved.Host)
+	require.NoError(t, resolved.loadTLSConfig())
+	assert.Equal(t, resolved.Host, resolved.TLSConfig.ServerName)
+
+	resolved, err = (&Config{
+		Host:            "arn:aws:dsql:us-west-2:123456789012:cluster/ijsamhssbh36dopuigphknejb4",
+		EndpointVariant: EndpointFIPSDualStack,
+	}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "ijsamhssbh36dopuigphknejb4.dsql-fips.us-west-2.api.aws", resolved.Host)
+	assert.Equal(t, "us-west-2", resolved.Region)
+
+	resolved, err = (&Config{Host: "arn:aws-us-gov:dsql:us-gov-west-1:123456789012:cluster/ijsamhssbh36dopuigphknejb4"}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "ijsamhssbh36dopuigphknejb4.dsql.us-gov-west-1.on.aws", resolved.Host)
+
+	// The variant does not rewrite a full hostname.
+	resolved, err = (&Config{Host: "mycluster.dsql.us-east-1.api.aws", EndpointVariant: EndpointFIPS}).resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "mycluster.dsql.us-east-1.api.aws", resolved.Host)
+	assert.Equal(t, "us-east-1", resolved.Region)
+
+	var fieldErr *FieldError
+	err = Config{Host: "ijsamhssbh36dopuigphknejb4", Region: "us-gov-west-1", EndpointVariant: EndpointFIPS}.Validate()
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "EndpointVariant", fieldErr.Field)
+	assert.Contains(t, err.Error(), `not available in partition "aws-us-gov"`)
+
+	err = Config{Host: "ijsamhssbh36dopuigphknejb4", Region: "us-east-1", EndpointVariant: "ipv6"}.Validate()
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "EndpointVariant", fieldErr.Field)
+
+	cfg, err := ParseConnectionString("dsql://admin@ijsamhssbh36dopuigphknejb4/postgres?region=us-east-1&endpointVariant=dualstack")
+	require.NoError(t, err)
+	assert.Equal(t, EndpointDualStack, cfg.EndpointVariant)
+}
@@ -8,31 +8,101 @@ package dsql
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 )
 
+// connectTokenCacheKey identifies a credential source shared by Connect calls.
+type connectTokenCacheKey struct {
+	source          CredentialSource
+	profile         string
+	webIdentity     string
+	assumeRole      string
+	region          string
+	duration        time.Duration
+	refreshFraction float64
+	clockSkew       bool
+	tokenAction     TokenAction
+}
+
+// connectTokenCache is a token cache shared by Connect calls, along with the
+// clock used to sign its tokens when clock skew compensation is enabled.
+type connectTokenCache struct {
+	cache *TokenCache
+	clock *skewClock
+}
+
+// maxConnectTokenCaches and maxConnectClocks bound the state shared by Connect
+// calls, so that a process connecting with many distinct configurations or to
+// many endpoints does not accumulate it. The least recently used entry is
+// evicted first.
+const (
+	maxConnectTokenCaches = 32
+	maxConnectClocks      = 256
+)
+
+// connectTokenCaches holds the token caches shared by Connect calls that do not use
+// custom or static credentials or a TimeSource, so that repeated calls reuse both
+// the resolved credentials and unexpired tokens. An evicted cache is invalidated,
+// releasing its tokens and cached credentials.
+var (
+	connectTokenCachesMu sync.Mutex
+	connectTokenCaches   = newLRUMap[connectTokenCacheKey, *connectTokenCache](maxConnectTokenCaches)
+)
+
+// connectClockKey identifies the reference a clock shared by Connect calls is
+// measured against: the server at host, or the configured TimeSource.
+type connectClockKey struct {
+	host       string
+	timeSource bool
+}
+
+// connectClocks holds the clocks shared by Connect calls that sign tokens without
+// a shared token cache, so that a skew measured on one connection corrects the
+// tokens of later ones.
+var (
+	connectClocksMu sync.Mutex
+	connectClocks   = newLRUMap[connectClockKey, *skewClock](maxConnectClocks)
+)
+
+// connectClock returns the shared clock for resolved, or nil if clock skew
+// compensation is disabled.
+func connectClock(resolved *resolvedConfig) *skewClock {
+	if !resolved.compensatesSkew() {
+		return nil
+	}
+	key := connectClockKey{host: resolved.Host, timeSource: resolved.TimeSource != nil}
+
+	connectClocksMu.Lock()
+	defer connectClocksMu.Unlock()
+	clock, ok := connectClocks.get(key)
+	if !ok {
+		clock = newSkewClock()
+		connectClocks.add(key, clock)
+	}
+	return clock
+}
+
+// newConnConfig returns the pgx.ConnConfig used by Connect, ignoring settings
+// from PG* environment variables.
+func (r *resolvedConfig) newConnConfig() (*pgx.ConnConfig, error) {
+	connConfig, err := pgx.ParseConfig(paramsDSN(r.Params, false))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create connection config: %w", err)
+	}
+	clearEnvSettings(connConfig, r.Params)
+	r.configureConnConfig(connConfig)
+	return connConfig, nil
+}
+
 // Connect creates a single connection to Aurora DSQL.
 // The config parameter can be a Config struct, *Config, or a connection string.
 func Connect(ctx context.Context, config any) (*pgx.Conn, error) {
-	var cfg *Config
-
-	switch c := config.(type) {
-	case Config:
-		cfg = &c
-	case *Config:
-		if c == nil {
-			return nil, fmt.Errorf("config cannot be nil")
-		}
-		cfg = c
-	case string:
-		parsed, err := ParseConnectionString(c)
-		if err != nil {
-			return nil, err
-		}
-		cfg = parsed
-	default:
-		return nil, fmt.Errorf("config must be Config, *Config, or string, got %T", config)
+	cfg, err := toConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
 	resolved, err := cfg.resolve()
@@ -44,28 +114,109 @@ func Connect(ctx context.Context, config any) (*pgx.Conn, error) {
 }
 
 func connectWithResolved(ctx context.Context, resolved *resolvedConfig) (*pgx.Conn, error) {
-	credentialsProvider, err := resolveCredentialsProvider(ctx, resolved)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve credentials provider: %w", err)
+	if err := resolved.loadTLSConfig(); err != nil {
+		return nil, err
 	}
 
-	token, err := GenerateToken(ctx, resolved.Host, resolved.Region, resolved.User, credentialsProvider, resolved.TokenDuration)
+	tokenProvider, clock, err := connectTokenProvider(ctx, resolved)
 	if err != nil {
 		return nil, err
 	}
 
-	connConfig, err := pgx.ParseConfig("")
+	connConfig, err := resolved.newConnConfig()
 	if err != nil {
-		return nil, fmt.Errorf("unable to create connection config: %w", err)
+		return nil, err
 	}
 
-	resolved.configureConnConfig(connConfig)
-	connConfig.Password = token
+	// On authentication rejection, discard cached credentials and tokens and
+	// retry with freshly generated ones, up to resolved.AuthRetries times.
+	for attempt := 0; ; attempt++ {
+		token, _, err := tokenProvider.Token(ctx, resolved.Host, resolved.Region, resolved.User)
+		if err != nil {
+			return nil, err
+		}
+		connConfig.Password = token
+
+		conn, err := pgx.ConnectConfig(ctx, connConfig)
+		if err == nil {
+			resolved.measureServerSkew(ctx, clock, conn)
+			return conn, nil
+		}
+		if !IsAuthError(err) {
+			return nil, fmt.Errorf("unable to connect: %w", err)
+		}
+		if attempt >= resolved.AuthRetries {
+			return nil, newAuthError(resolved, clock, err)
+		}
+		invalidateTokenProvider(tokenProvider)
+	}
+}
+
+// connectTokenProvider returns the token provider to use for a single connection
+// and, when clock skew compensation is enabled, the clock it signs with.
+// A custom TokenProvider is used as-is, without a clock since nothing is signed.
+// Configurations with custom or static credentials or a TimeSource generate a
+// fresh token, since they cannot be compared to decide whether a cache may be
+// shared, but sign with a clock shared by Connect calls to the same endpoint.
+func connectTokenProvider(ctx context.Context, resolved *resolvedConfig) (TokenProvider, *skewClock, error) {
+	if resolved.TokenProvider != nil {
+		return resolved.TokenProvider, nil, nil
+	}
 
-	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	source := resolved.effectiveCredentialSource()
+	if source == CredentialSourceCustom || source == CredentialSourceStatic || resolved.TimeSource != nil {
+		credentialsProvider, err := resolveCredentialsProvider(ctx, resolved)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve credentials provider: %w", err)
+		}
+		clock := connectClock(resolved)
+		return resolved.newSigV4TokenProvider(credentialsProvider, clock), clock, nil
+	}
+
+	key := connectTokenCacheKey{
+		source:          source,
+		profile:         resolved.Profile,
+		webIdentity:     resolved.WebIdentityTokenFile + "\x00" + resolved.WebIdentityRoleARN,
+		assumeRole:      fmt.Sprint(resolved.AssumeRole),
+		region:          resolved.Region,
+		duration:        resolved.TokenDuration,
+		refreshFraction: resolved.TokenRefreshFraction,
+		clockSkew:       resolved.ClockSkewCompensation,
+		tokenAction:     resolved.TokenAction,
+	}
+
+	connectTokenCachesMu.Lock()
+	shared, ok := connectTokenCaches.get(key)
+	connectTokenCachesMu.Unlock()
+	if ok {
+		return shared.cache, shared.clock, nil
+	}
+
+	credentialsProvider, err := resolveCredentialsProvider(ctx, resolved)
 	if err != nil {
-		return nil, fmt.Errorf("unable to connect: %w", err)
+		return nil, nil, fmt.Errorf("failed to resolve credentials provider: %w", err)
 	}
 
-	return conn, nil
+	connectTokenCachesMu.Lock()
+	if shared, ok := connectTokenCaches.get(key); ok {
+		connectTokenCachesMu.Unlock()
+		return shared.cache, shared.clock, nil
+	}
+	var clock *skewClock
+	if resolved.compensatesSkew() {
+		clock = newSkewClock()
+	}
+	shared = &connectTokenCache{
+		cache: NewTokenCache(resolved.newSigV4TokenProvider(credentialsProvider, clock), resolved.TokenRefreshFraction),
+		clock: clock,
+	}
+	evicted, ok := connectTokenCaches.add(key, shared)
+	connectTokenCachesMu.Unlock()
+
+	// Connect calls still holding the evicted cache keep working; it just
+	// no longer retains anything for them to share.
+	if ok {
+		evicted.cache.Invalidate()
+	}
+	return shared.cache, shared.clock, nil
 }
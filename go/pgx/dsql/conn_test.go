@@ -7,9 +7,11 @@ package dsql
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -65,3 +67,45 @@ func TestConnectFromConnectionString(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 1, result)
 }
+
+func TestConnectTokenProviderClock(t *testing.T) {
+	ctx := context.Background()
+	resolve := func(cfg Config) *resolvedConfig {
+		t.Helper()
+		cfg.Host = testHost
+		cfg.ClockSkewCompensation = true
+		resolved, err := cfg.resolve()
+		require.NoError(t, err)
+		return resolved
+	}
+
+	// Nothing is signed with a custom token provider, so there is no clock.
+	_, clock, err := connectTokenProvider(ctx, resolve(Config{TokenProvider: &staticTokenProvider{token: "t"}}))
+	require.NoError(t, err)
+	assert.Nil(t, clock)
+
+	// Connect calls that cannot share a token cache share a clock.
+	static := Config{StaticCredentials: &aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}}
+	_, first, err := connectTokenProvider(ctx, resolve(static))
+	require.NoError(t, err)
+	require.NotNil(t, first)
+	_, second, err := connectTokenProvider(ctx, resolve(static))
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+}
+
+func TestConnectClocksBounded(t *testing.T) {
+	cfg := Config{Host: testHost, ClockSkewCompensation: true}
+	resolved, err := cfg.resolve()
+	require.NoError(t, err)
+	first := connectClock(resolved)
+
+	for i := 0; i < maxConnectClocks; i++ {
+		other := *resolved
+		other.Host = fmt.Sprintf("host%d.example.com", i)
+		connectClock(&other)
+	}
+
+	assert.LessOrEqual(t, connectClocks.len(), maxConnectClocks)
+	assert.NotSame(t, first, connectClock(resolved))
+}
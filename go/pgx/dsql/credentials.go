@@ -0,0 +1,243 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// CredentialSource selects where the connector obtains AWS credentials.
+type CredentialSource string
+
+const (
+	// CredentialSourceAuto infers the source from the other Config fields:
+	// CustomCredentialsProvider if set, then Profile if set, then the default chain.
+	CredentialSourceAuto CredentialSource = ""
+
+	// CredentialSourceDefault uses the AWS SDK default credential chain.
+	CredentialSourceDefault CredentialSource = "default"
+
+	// CredentialSourceProfile uses the shared config profile named by Config.Profile.
+	CredentialSourceProfile CredentialSource = "profile"
+
+	// CredentialSourceStatic uses the keys in Config.StaticCredentials.
+	CredentialSourceStatic CredentialSource = "static"
+
+	// CredentialSourceWebIdentity exchanges a web identity token file for role
+	// credentials, as used by EKS IAM roles for service accounts.
+	CredentialSourceWebIdentity CredentialSource = "webIdentity"
+
+	// CredentialSourceContainer uses the container credentials endpoint, as used by
+	// EKS Pod Identity and ECS task roles.
+	CredentialSourceContainer CredentialSource = "container"
+
+	// CredentialSourceIMDS uses the EC2 instance metadata service.
+	CredentialSourceIMDS CredentialSource = "imds"
+
+	// CredentialSourceCustom uses Config.CustomCredentialsProvider.
+	CredentialSourceCustom CredentialSource = "custom"
+)
+
+// Container credentials endpoint defaults, matching the AWS SDK.
+const (
+	containerCredentialsHost   = "http://169.254.170.2"
+	containerCredentialsEnvURI = "AWS_CONTAINER_CREDENTIALS_FULL_URI"
+	containerCredentialsEnvRel = "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"
+	containerAuthTokenEnv      = "AWS_CONTAINER_AUTHORIZATION_TOKEN"
+	containerAuthTokenFileEnv  = "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"
+)
+
+// validCredentialSource reports whether s is a known credential source.
+func validCredentialSource(s CredentialSource) bool {
+	switch s {
+	case CredentialSourceAuto, CredentialSourceDefault, CredentialSourceProfile, CredentialSourceStatic,
+		CredentialSourceWebIdentity, CredentialSourceContainer, CredentialSourceIMDS, CredentialSourceCustom:
+		return true
+	}
+	return false
+}
+
+// effectiveCredentialSource returns the credential source used for resolved,
+// resolving CredentialSourceAuto from the other fields.
+func (r *resolvedConfig) effectiveCredentialSource() CredentialSource {
+	if r.CredentialSource != CredentialSourceAuto {
+		return r.CredentialSource
+	}
+	switch {
+	case r.CustomCredentialsProvider != nil:
+		return CredentialSourceCustom
+	case r.Profile != "" || (len(r.AssumeRole) > 0 && r.AssumeRole[0].SourceProfile != ""):
+		return CredentialSourceProfile
+	default:
+		return CredentialSourceDefault
+	}
+}
+
+// validateCredentialSource checks that the fields required by the selected
+// credential source are present.
+func (c *Config) validateCredentialSource() error {
+	if !validCredentialSource(c.CredentialSource) {
+		return configErrorf("unknown credential source %q", c.CredentialSource)
+	}
+
+	hasSourceProfile := len(c.AssumeRole) > 0 && c.AssumeRole[0].SourceProfile != ""
+	switch c.CredentialSource {
+	case CredentialSourceProfile:
+		if c.Profile == "" && !hasSourceProfile {
+			return configErrorf("credential source %q requires a profile", c.CredentialSource)
+		}
+	case CredentialSourceStatic:
+		if c.StaticCredentials == nil || c.StaticCredentials.AccessKeyID == "" || c.StaticCredentials.SecretAccessKey == "" {
+			return configErrorf("credential source %q requires static credentials with an access key ID and secret access key", c.CredentialSource)
+		}
+	case CredentialSourceCustom:
+		if c.CustomCredentialsProvider == nil {
+			return configErrorf("credential source %q requires a custom credentials provider", c.CredentialSource)
+		}
+	}
+
+	if hasSourceProfile && c.CredentialSource != CredentialSourceAuto && c.CredentialSource != CredentialSourceProfile {
+		return configErrorf("assume role source profile cannot be used with credential source %q", c.CredentialSource)
+	}
+	return nil
+}
+
+// baseCredentialsProvider returns the credentials provider for source, before any
+// role chain is applied. cfg is the loaded AWS config, used for STS and for the
+// default and profile sources.
+func (r *resolvedConfig) baseCredentialsProvider(cfg aws.Config, source CredentialSource) (aws.CredentialsProvider, error) {
+	switch source {
+	case CredentialSourceStatic:
+		return credentials.NewStaticCredentialsProvider(
+			r.StaticCredentials.AccessKeyID,
+			r.StaticCredentials.SecretAccessKey,
+			r.StaticCredentials.SessionToken,
+		), nil
+	case CredentialSourceCustom:
+		return r.CustomCredentialsProvider, nil
+	case CredentialSourceWebIdentity:
+		return r.webIdentityProvider(cfg)
+	case CredentialSourceContainer:
+		return containerProvider()
+	case CredentialSourceIMDS:
+		return aws.NewCredentialsCache(ec2rolecreds.New()), nil
+	default:
+		return cfg.Credentials, nil
+	}
+}
+
+// webIdentityProvider builds a web identity provider from the Config fields,
+// falling back to the standard AWS_WEB_IDENTITY_TOKEN_FILE, AWS_ROLE_ARN and
+// AWS_ROLE_SESSION_NAME environment variables.
+func (r *resolvedConfig) webIdentityProvider(cfg aws.Config) (aws.CredentialsProvider, error) {
+	tokenFile := r.WebIdentityTokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	roleARN := r.WebIdentityRoleARN
+	if roleARN == "" {
+		roleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+	if tokenFile == "" || roleARN == "" {
+		return nil, fmt.Errorf("web identity credentials require a token file and role ARN (set WebIdentityTokenFile and WebIdentityRoleARN, or AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN)")
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+
+	provider := stscreds.NewWebIdentityRoleProvider(sts.NewFromConfig(cfg), roleARN, stscreds.IdentityTokenFile(tokenFile),
+		func(o *stscreds.WebIdentityRoleOptions) {
+			if sessionName != "" {
+				o.RoleSessionName = sessionName
+			}
+		})
+	return aws.NewCredentialsCache(provider), nil
+}
+
+// containerProvider builds a container credentials endpoint provider from the
+// standard AWS_CONTAINER_* environment variables.
+func containerProvider() (aws.CredentialsProvider, error) {
+	endpoint := os.Getenv(containerCredentialsEnvURI)
+	if endpoint == "" {
+		relative := os.Getenv(containerCredentialsEnvRel)
+		if relative == "" {
+			return nil, fmt.Errorf("container credentials require %s or %s", containerCredentialsEnvURI, containerCredentialsEnvRel)
+		}
+		endpoint = containerCredentialsHost + relative
+	}
+
+	provider := endpointcreds.New(endpoint, func(o *endpointcreds.Options) {
+		if tokenFile := os.Getenv(containerAuthTokenFileEnv); tokenFile != "" {
+			o.AuthorizationTokenProvider = endpointcreds.TokenProviderFunc(func() (string, error) {
+				data, err := os.ReadFile(tokenFile)
+				if err != nil {
+					return "", fmt.Errorf("failed to read container authorization token: %w", err)
+				}
+				return strings.TrimSpace(string(data)), nil
+			})
+		} else {
+			o.AuthorizationToken = os.Getenv(containerAuthTokenEnv)
+		}
+	})
+	return aws.NewCredentialsCache(provider), nil
+}
+
+// CredentialsInfo describes the AWS credentials the connector resolved for a Config.
+type CredentialsInfo struct {
+	// Source is the credential source that was selected.
+	Source CredentialSource
+
+	// ProviderName is the name reported by the AWS SDK provider that supplied
+	// the credentials, such as "EnvConfigCredentials" or "WebIdentityCredentials".
+	ProviderName string
+
+	// AccessKeyID is the access key ID of the retrieved credentials.
+	AccessKeyID string
+
+	// Provider is the resolved credentials provider, including any role chain.
+	Provider aws.CredentialsProvider
+}
+
+// ResolveCredentials resolves and retrieves AWS credentials for config the same
+// way NewPool and Connect do, and reports which source supplied them.
+// This is intended for debugging credential resolution.
+// The config parameter can be a Config struct, *Config, or a connection string.
+func ResolveCredentials(ctx context.Context, config any) (*CredentialsInfo, error) {
+	cfg, err := toConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := cfg.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := resolveCredentialsProvider(ctx, resolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve credentials provider: %w", err)
+	}
+
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		return nil, &CredentialsError{Source: resolved.effectiveCredentialSource(), Err: err}
+	}
+
+	return &CredentialsInfo{
+		Source:       resolved.effectiveCredentialSource(),
+		ProviderName: creds.Source,
+		AccessKeyID:  creds.AccessKeyID,
+		Provider:     provider,
+	}, nil
+}
@@ -0,0 +1,363 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SOCKS5 protocol constants (RFC 1928 and RFC 1929).
+const (
+	socks5Version          = 5
+	socks5AuthNone         = 0
+	socks5AuthPassword     = 2
+	socks5AuthNoAcceptable = 0xff
+	socks5CmdConnect       = 1
+	socks5AddrIPv4         = 1
+	socks5AddrDomain       = 3
+	socks5AddrIPv6         = 4
+	socks5PasswordVersion  = 1
+)
+
+// NewProxyDialer returns a dial function for Config.Dialer that connects
+// through the proxy at proxyURL. Supported schemes are socks5 (the target
+// hostname is resolved locally), socks5h (resolved by the proxy), http and
+// https (HTTP CONNECT). Credentials in the URL are used to authenticate to the
+// proxy.
+func NewProxyDialer(proxyURL string) (pgconn.DialFunc, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, configErrorf("invalid proxy URL: %w", err)
+	}
+	if u.Hostname() == "" {
+		return nil, configErrorf("invalid proxy URL %q: missing host", u.Redacted())
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return socks5Dialer(u), nil
+	case "http", "https":
+		return httpConnectDialer(u), nil
+	default:
+		return nil, configErrorf("unsupported proxy scheme %q: must be socks5, socks5h, http or https", u.Scheme)
+	}
+}
+
+// ProxyFromEnvironment returns a dial function for Config.Dialer that connects
+// through the proxy named by ALL_PROXY or, if unset, HTTPS_PROXY (or their
+// lowercase forms). Hosts matching NO_PROXY are dialed directly, as are all
+// hosts if no proxy is set.
+func ProxyFromEnvironment() (pgconn.DialFunc, error) {
+	proxyURL := getenvAny("ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy")
+	direct := (&net.Dialer{}).DialContext
+	if proxyURL == "" {
+		return direct, nil
+	}
+
+	dial, err := NewProxyDialer(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	noProxy := getenvAny("NO_PROXY", "no_proxy")
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if bypassProxy(noProxy, addr) {
+			return direct(ctx, network, addr)
+		}
+		return dial(ctx, network, addr)
+	}, nil
+}
+
+// getenvAny returns the value of the first of names that is set and non-empty.
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// bypassProxy reports whether addr matches the NO_PROXY list noProxy: "*", a
+// hostname or IP address, or a domain suffix such as ".example.com" or
+// "example.com", each optionally with a port.
+func bypassProxy(noProxy, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialProxy connects to the proxy server itself, with TLS for https proxies.
+func dialProxy(ctx context.Context, proxy *url.URL, defaultPort string) (net.Conn, error) {
+	addr := proxy.Host
+	if proxy.Port() == "" {
+		addr = net.JoinHostPort(proxy.Hostname(), defaultPort)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to proxy %s: %w", proxy.Redacted(), err)
+	}
+	if proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to connect to proxy %s: %w", proxy.Redacted(), err)
+		}
+		conn = tlsConn
+	}
+	return conn, nil
+}
+
+// handshakeWithContext runs handshake on conn, aborting it if ctx is done.
+// conn is closed if the handshake fails.
+func handshakeWithContext(ctx context.Context, conn net.Conn, handshake func() error) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	err := handshake()
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	return nil
+}
+
+// socks5Dialer returns a dial function that connects through a SOCKS5 proxy.
+func socks5Dialer(proxy *url.URL) pgconn.DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in %q: %w", addr, err)
+		}
+		if proxy.Scheme == "socks5" && net.ParseIP(host) == nil {
+			ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			host = ips[0].IP.String()
+		}
+
+		conn, err := dialProxy(ctx, proxy, "1080")
+		if err != nil {
+			return nil, err
+		}
+		err = handshakeWithContext(ctx, conn, func() error {
+			return socks5Connect(conn, proxy.User, host, uint16(port))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("SOCKS5 proxy %s: %w", proxy.Redacted(), err)
+		}
+		return conn, nil
+	}
+}
+
+// socks5Connect negotiates authentication and a CONNECT to host:port on conn.
+func socks5Connect(conn net.Conn, user *url.Userinfo, host string, port uint16) error {
+	methods := []byte{socks5AuthNone}
+	if user != nil {
+		methods = []byte{socks5AuthPassword}
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected protocol version %d", reply[0])
+	}
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if user == nil {
+			return errors.New("proxy requires authentication")
+		}
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return errors.New("proxy username and password must be at most 255 bytes")
+		}
+		msg := []byte{socks5PasswordVersion, byte(len(user.Username()))}
+		msg = append(msg, user.Username()...)
+		msg = append(msg, byte(len(password)))
+		msg = append(msg, password...)
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("proxy authentication failed")
+		}
+	case socks5AuthNoAcceptable:
+		return errors.New("proxy accepted none of the offered authentication methods")
+	default:
+		return fmt.Errorf("proxy selected unsupported authentication method %d", reply[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("hostname %q is too long", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5AddrIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5AddrIPv6)
+		req = append(req, ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		return fmt.Errorf("connect to %s failed with reply code %d", net.JoinHostPort(host, strconv.Itoa(int(port))), header[1])
+	}
+
+	// Skip the bound address and port.
+	var skip int
+	switch header[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len + 2
+	case socks5AddrIPv6:
+		skip = net.IPv6len + 2
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("unexpected address type %d", header[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// httpConnectDialer returns a dial function that connects through an HTTP
+// proxy with the CONNECT method.
+func httpConnectDialer(proxy *url.URL) pgconn.DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		defaultPort := "80"
+		if proxy.Scheme == "https" {
+			defaultPort = "443"
+		}
+		conn, err := dialProxy(ctx, proxy, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+
+		var reader *bufio.Reader
+		err = handshakeWithContext(ctx, conn, func() error {
+			req := &http.Request{
+				Method: http.MethodConnect,
+				URL:    &url.URL{Opaque: addr},
+				Host:   addr,
+				Header: make(http.Header),
+			}
+			if proxy.User != nil {
+				password, _ := proxy.User.Password()
+				req.SetBasicAuth(proxy.User.Username(), password)
+				req.Header["Proxy-Authorization"] = req.Header["Authorization"]
+				delete(req.Header, "Authorization")
+			}
+			if err := req.Write(conn); err != nil {
+				return err
+			}
+
+			reader = bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("HTTP proxy %s: %w", proxy.Redacted(), err)
+		}
+
+		if reader.Buffered() > 0 {
+			return &bufferedConn{Conn: conn, reader: reader}, nil
+		}
+		return conn, nil
+	}
+}
+
+// bufferedConn is a net.Conn whose first reads come from a bufio.Reader that
+// may hold data read past the proxy's response.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// lookupPassthrough is a pgconn.LookupFunc that leaves host unresolved, so that
+// a custom dialer receives the hostname and can resolve it remotely.
+func lookupPassthrough(_ context.Context, host string) ([]string, error) {
+	return []string{host}, nil
+}
@@ -0,0 +1,329 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve accepts connections on a loopback listener and handles each with handle.
+func serve(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// echoServer returns the address of a server that echoes what it reads.
+func echoServer(t *testing.T) string {
+	return serve(t, func(conn net.Conn) { _, _ = io.Copy(conn, conn) })
+}
+
+// fakeProxy records the targets requested of a test proxy and forwards every
+// connection to backend.
+type fakeProxy struct {
+	backend string
+
+	mu      sync.Mutex
+	targets []string
+	auth    []string
+}
+
+func (p *fakeProxy) record(target, auth string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.targets = append(p.targets, target)
+	p.auth = append(p.auth, auth)
+}
+
+func (p *fakeProxy) forward(client io.ReadWriter) {
+	backend, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		return
+	}
+	defer backend.Close()
+	go func() { _, _ = io.Copy(backend, client) }()
+	_, _ = io.Copy(client, backend)
+}
+
+// socks5 serves the SOCKS5 protocol, requiring user/password if set.
+func (p *fakeProxy) socks5(user, password string) func(net.Conn) {
+	return func(conn net.Conn) {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		methods := make([]byte, header[1])
+		_, _ = io.ReadFull(conn, methods)
+
+		auth := ""
+		if user != "" {
+			_, _ = conn.Write([]byte{socks5Version, socks5AuthPassword})
+			r := bufio.NewReader(conn)
+			_, _ = r.ReadByte()
+			ulen, _ := r.ReadByte()
+			u := make([]byte, ulen)
+			_, _ = io.ReadFull(r, u)
+			plen, _ := r.ReadByte()
+			pw := make([]byte, plen)
+			_, _ = io.ReadFull(r, pw)
+			if string(u) != user || string(pw) != password {
+				_, _ = conn.Write([]byte{socks5PasswordVersion, 1})
+				return
+			}
+			_, _ = conn.Write([]byte{socks5PasswordVersion, 0})
+			auth = string(u)
+		} else {
+			_, _ = conn.Write([]byte{socks5Version, socks5AuthNone})
+		}
+
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		var host string
+		switch req[3] {
+		case socks5AddrIPv4:
+			ip := make([]byte, net.IPv4len)
+			_, _ = io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case socks5AddrDomain:
+			length := make([]byte, 1)
+			_, _ = io.ReadFull(conn, length)
+			name := make([]byte, length[0])
+			_, _ = io.ReadFull(conn, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		_, _ = io.ReadFull(conn, port)
+		p.record(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), auth)
+
+		_, _ = conn.Write([]byte{socks5Version, 0, 0, socks5AddrIPv4, 127, 0, 0, 1, 0, 0})
+		p.forward(conn)
+	}
+}
+
+// httpConnect serves HTTP CONNECT requests.
+func (p *fakeProxy) httpConnect(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil || req.Method != http.MethodConnect {
+		_, _ = conn.Write([]byte("HTTP/1.1 405 Method Not Allowed\r\n\r\n"))
+		return
+	}
+	p.record(req.Host, req.Header.Get("Proxy-Authorization"))
+	if req.Host == "forbidden.example.com:5432" {
+		_, _ = conn.Write([]byte("HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n"))
+		return
+	}
+	_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	p.forward(struct {
+		io.Reader
+		io.Writer
+	}{reader, conn})
+}
+
+// assertEcho checks that conn carries data to and from an echo server.
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestSOCKS5Dialer(t *testing.T) {
+	ctx := context.Background()
+	proxy := &fakeProxy{backend: echoServer(t)}
+
+	// socks5h leaves name resolution to the proxy.
+	dial, err := NewProxyDialer("socks5h://" + serve(t, proxy.socks5("", "")))
+	require.NoError(t, err)
+	conn, err := dial(ctx, "tcp", "mycluster.dsql.us-east-1.on.aws:5432")
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+
+	// socks5 resolves names locally.
+	dial, err = NewProxyDialer("socks5://" + serve(t, proxy.socks5("", "")))
+	require.NoError(t, err)
+	conn, err = dial(ctx, "tcp", "localhost:5432")
+	require.NoError(t, err)
+	conn.Close()
+
+	// Credentials in the URL authenticate to the proxy.
+	authAddr := serve(t, proxy.socks5("dev", "s3cret"))
+	dial, err = NewProxyDialer("socks5h://dev:s3cret@" + authAddr)
+	require.NoError(t, err)
+	conn, err = dial(ctx, "tcp", "mycluster.dsql.us-east-1.on.aws:5432")
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+
+	assert.Equal(t, []string{
+		"mycluster.dsql.us-east-1.on.aws:5432",
+		"127.0.0.1:5432",
+		"mycluster.dsql.us-east-1.on.aws:5432",
+	}, proxy.targets)
+	assert.Equal(t, "dev", proxy.auth[2])
+
+	dial, err = NewProxyDialer("socks5h://dev:wrong@" + authAddr)
+	require.NoError(t, err)
+	_, err = dial(ctx, "tcp", "mycluster.dsql.us-east-1.on.aws:5432")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "proxy authentication failed")
+	assert.NotContains(t, err.Error(), "wrong")
+}
+
+func TestHTTPConnectDialer(t *testing.T) {
+	ctx := context.Background()
+	proxy := &fakeProxy{backend: echoServer(t)}
+	addr := serve(t, proxy.httpConnect)
+
+	dial, err := NewProxyDialer("http://dev:s3cret@" + addr)
+	require.NoError(t, err)
+	conn, err := dial(ctx, "tcp", "mycluster.dsql.us-east-1.on.aws:5432")
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+
+	assert.Equal(t, []string{"mycluster.dsql.us-east-1.on.aws:5432"}, proxy.targets)
+	assert.Equal(t, "Basic ZGV2OnMzY3JldA==", proxy.auth[0])
+
+	_, err = dial(ctx, "tcp", "forbidden.example.com:5432")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403 Forbidden")
+	assert.NotContains(t, err.Error(), "s3cret")
+}
+
+func TestNewProxyDialerErrors(t *testing.T) {
+	_, err := NewProxyDialer("ftp://proxy.example.com")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), `unsupported proxy scheme "ftp"`)
+
+	_, err = NewProxyDialer("socks5://")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	ctx := context.Background()
+	for _, name := range []string{"ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+	backend := echoServer(t)
+	proxy := &fakeProxy{backend: backend}
+
+	t.Setenv("HTTPS_PROXY", "http://"+serve(t, proxy.httpConnect))
+	t.Setenv("no_proxy", "localhost,.internal.example.com")
+
+	dial, err := ProxyFromEnvironment()
+	require.NoError(t, err)
+	conn, err := dial(ctx, "tcp", "mycluster.dsql.us-east-1.on.aws:5432")
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+
+	// Hosts in NO_PROXY are dialed directly.
+	_, port, _ := net.SplitHostPort(backend)
+	conn, err = dial(ctx, "tcp", net.JoinHostPort("localhost", port))
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+	assert.Equal(t, []string{"mycluster.dsql.us-east-1.on.aws:5432"}, proxy.targets)
+
+	// ALL_PROXY takes precedence over HTTPS_PROXY.
+	t.Setenv("ALL_PROXY", "socks5h://"+serve(t, proxy.socks5("", "")))
+	dial, err = ProxyFromEnvironment()
+	require.NoError(t, err)
+	conn, err = dial(ctx, "tcp", "other.dsql.us-east-1.on.aws:5432")
+	require.NoError(t, err)
+	conn.Close()
+	assert.Len(t, proxy.targets, 2)
+
+	t.Setenv("ALL_PROXY", "gopher://proxy")
+	_, err = ProxyFromEnvironment()
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestBypassProxy(t *testing.T) {
+	tests := []struct {
+		noProxy string
+		addr    string
+		bypass  bool
+	}{
+		{"", "cluster.dsql.us-east-1.on.aws:5432", false},
+		{"*", "cluster.dsql.us-east-1.on.aws:5432", true},
+		{"on.aws", "cluster.dsql.us-east-1.on.aws:5432", true},
+		{".on.aws", "cluster.dsql.us-east-1.on.aws:5432", true},
+		{"*.on.aws", "cluster.dsql.us-east-1.on.aws:5432", true},
+		{"n.aws", "cluster.dsql.us-east-1.on.aws:5432", false},
+		{"example.com, 10.0.0.5", "10.0.0.5:5432", true},
+		{"on.aws:5433", "cluster.dsql.us-east-1.on.aws:5432", false},
+		{"on.aws:5432", "cluster.dsql.us-east-1.on.aws:5432", true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.bypass, bypassProxy(tt.noProxy, tt.addr), "NO_PROXY=%q addr=%q", tt.noProxy, tt.addr)
+	}
+}
+
+func TestConfigDialer(t *testing.T) {
+	pki := newTestPKI(t, testTLSHost)
+	backend := serve(t, func(conn net.Conn) {
+		_ = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{pki.server}}).Handshake()
+	})
+	proxy := &fakeProxy{backend: backend}
+	dial, err := NewProxyDialer("socks5h://" + serve(t, proxy.socks5("", "")))
+	require.NoError(t, err)
+
+	resolved, err := (&Config{Host: testTLSHost, Dialer: dial, TLS: &TLSConfig{RootCAs: pki.pool()}}).resolve()
+	require.NoError(t, err)
+	require.NoError(t, resolved.loadTLSConfig())
+	connConfig, err := pgx.ParseConfig("")
+	require.NoError(t, err)
+	resolved.configureConnConfig(connConfig)
+
+	// The dialer receives the unresolved hostname.
+	hosts, err := connConfig.LookupFunc(context.Background(), connConfig.Host)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testTLSHost}, hosts)
+
+	// TLS through the proxy verifies the cluster hostname.
+	conn, err := connConfig.DialFunc(context.Background(), "tcp", net.JoinHostPort(hosts[0], "5432"))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, tls.Client(conn, connConfig.TLSConfig).Handshake())
+	assert.Equal(t, []string{testTLSHost + ":5432"}, proxy.targets)
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// urlSchemePattern matches the scheme of a URL connection string.
+var urlSchemePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*://`)
+
+// isKeywordValueDSN reports whether connStr is a libpq keyword/value connection
+// string rather than a URL. Values may themselves contain "://", as in
+// sslrootcert=file:///etc/ssl/ca.pem.
+func isKeywordValueDSN(connStr string) bool {
+	return !urlSchemePattern.MatchString(connStr) && strings.Contains(connStr, "=")
+}
+
+// parseKeywordValueDSN parses a libpq keyword/value connection string such as
+// "host=cluster.dsql.us-east-1.on.aws user=admin region=us-east-1" into a Config.
+// The host, port, user and dbname keywords set the corresponding fields; all
+// other keywords are handled like URL query parameters.
+func parseKeywordValueDSN(connStr string) (*Config, error) {
+	params, err := splitKeywordValues(connStr)
+	if err != nil {
+		return nil, err
+	}
+	return configFromKeywords(params)
+}
+
+// configFromKeywords builds a Config from libpq-style keywords. The host, port,
+// user and dbname keywords set the corresponding fields; all other keywords are
+// handled like URL query parameters. params is modified.
+func configFromKeywords(params url.Values) (*Config, error) {
+	if err := checkRepeatedParams(params); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Host: params.Get("host"),
+		User: params.Get("user"),
+	}
+
+	cfg.Database = params.Get("dbname")
+	if cfg.Database == "" {
+		cfg.Database = params.Get("database")
+	}
+
+	if portStr := params.Get("port"); portStr != "" {
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return nil, configErrorf("invalid port: %w", err)
+		}
+		cfg.Port = int(port)
+	}
+
+	for _, key := range []string{"host", "port", "user", "dbname", "database"} {
+		params.Del(key)
+	}
+
+	if err := parseConnParams(cfg, params); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// splitKeywordValues splits a keyword/value connection string into its
+// parameters, following libpq's rules: values may be single-quoted, and a
+// backslash escapes the next character in both quoted and unquoted values.
+// Keywords may be repeated; repeated values are kept in order.
+func splitKeywordValues(connStr string) (url.Values, error) {
+	params := make(url.Values)
+	s := strings.TrimLeftFunc(connStr, unicode.IsSpace)
+
+	for s != "" {
+		eq := strings.IndexRune(s, '=')
+		if eq < 0 {
+			return nil, configErrorf("invalid connection string: missing \"=\" after %q", s)
+		}
+		key := strings.TrimRightFunc(s[:eq], unicode.IsSpace)
+		if key == "" || strings.IndexFunc(key, unicode.IsSpace) >= 0 {
+			return nil, configErrorf("invalid connection string: invalid keyword %q", key)
+		}
+		s = strings.TrimLeftFunc(s[eq+1:], unicode.IsSpace)
+
+		var value strings.Builder
+		if strings.HasPrefix(s, "'") {
+			s = s[1:]
+			closed := false
+			for s != "" {
+				c := s[0]
+				s = s[1:]
+				if c == '\\' && s != "" {
+					value.WriteByte(s[0])
+					s = s[1:]
+					continue
+				}
+				if c == '\'' {
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return nil, configErrorf("invalid connection string: unterminated quoted value for %q", key)
+			}
+		} else {
+			for s != "" {
+				if r, _ := utf8.DecodeRuneInString(s); unicode.IsSpace(r) {
+					break
+				}
+				c := s[0]
+				s = s[1:]
+				if c == '\\' && s != "" {
+					c = s[0]
+					s = s[1:]
+				}
+				value.WriteByte(c)
+			}
+		}
+
+		params.Add(key, value.String())
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	}
+
+	return params, nil
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitKeywordValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		connStr  string
+		expected url.Values
+	}{
+		{
+			name:     "simple",
+			connStr:  "host=cluster user=admin",
+			expected: url.Values{"host": {"cluster"}, "user": {"admin"}},
+		},
+		{
+			name:     "spaces around equals",
+			connStr:  "  host = cluster\tuser= admin  ",
+			expected: url.Values{"host": {"cluster"}, "user": {"admin"}},
+		},
+		{
+			name:     "quoted value with escapes",
+			connStr:  `profile='my profile' webIdentityTokenFile='/var/run/it\'s \\ here'`,
+			expected: url.Values{"profile": {"my profile"}, "webIdentityTokenFile": {`/var/run/it's \ here`}},
+		},
+		{
+			name:     "empty quoted value",
+			connStr:  "user='' host=cluster",
+			expected: url.Values{"user": {""}, "host": {"cluster"}},
+		},
+		{
+			name:     "escaped space in unquoted value",
+			connStr:  `profile=my\ profile`,
+			expected: url.Values{"profile": {"my profile"}},
+		},
+		{
+			name:     "multibyte characters",
+			connStr:  "application_name=àą search_path=数据",
+			expected: url.Values{"application_name": {"àą"}, "search_path": {"数据"}},
+		},
+		{
+			name:     "repeated keywords",
+			connStr:  "assumeRoleArn=arn:aws:iam::111111111111:role/A assumeRoleArn=arn:aws:iam::222222222222:role/B",
+			expected: url.Values{"assumeRoleArn": {"arn:aws:iam::111111111111:role/A", "arn:aws:iam::222222222222:role/B"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := splitKeywordValues(tt.connStr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, params)
+		})
+	}
+}
+
+func TestSplitKeywordValuesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		connStr string
+		errMsg  string
+	}{
+		{"missing equals", "host=cluster user", `missing "="`},
+		{"empty keyword", "=cluster", "invalid keyword"},
+		{"unterminated quote", "host='cluster", "unterminated quoted value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := splitKeywordValues(tt.connStr)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestIsKeywordValueDSN(t *testing.T) {
+	assert.True(t, isKeywordValueDSN("host=mycluster.dsql.us-east-1.on.aws"))
+	assert.True(t, isKeywordValueDSN("host=mycluster.dsql.us-east-1.on.aws sslrootcert=file:///etc/ssl/ca.pem"))
+	assert.False(t, isKeywordValueDSN("postgres://mycluster.dsql.us-east-1.on.aws/postgres?sslmode=verify-full"))
+	assert.False(t, isKeywordValueDSN("dsql://admin@mycluster.dsql.us-east-1.on.aws/postgres?region=us-east-1"))
+	assert.False(t, isKeywordValueDSN("mycluster.dsql.us-east-1.on.aws"))
+}
+
+func TestParseConnectionStringKeywordValue(t *testing.T) {
+	cfg, err := ParseConnectionString("host=mycluster.dsql.us-east-1.on.aws port=5433 user=app dbname=orders region=eu-west-1 profile='dev profile' tokenDurationSecs=300")
+	require.NoError(t, err)
+	assert.Equal(t, &Config{
+		Host:              "mycluster.dsql.us-east-1.on.aws",
+		Port:              5433,
+		User:              "app",
+		Database:          "orders",
+		Region:            "eu-west-1",
+		Profile:           "dev profile",
+		TokenDurationSecs: 300,
+	}, cfg)
+
+	// The region is parsed from the host when not given, as with URLs.
+	cfg, err = ParseConnectionString("host=mycluster.dsql.us-west-2.on.aws")
+	require.NoError(t, err)
+	resolved, err := cfg.resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "us-west-2", resolved.Region)
+	assert.Equal(t, DefaultUser, resolved.User)
+
+	cfg, err = ParseConnectionString("host=mycluster.dsql.us-east-1.on.aws database=alt")
+	require.NoError(t, err)
+	assert.Equal(t, "alt", cfg.Database)
+
+	_, err = ParseConnectionString("host=mycluster.dsql.us-east-1.on.aws port=abc")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "invalid port")
+
+	_, err = ParseConnectionString("host=mycluster.dsql.us-east-1.on.aws tokenDurationSecs=soon")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid tokenDurationSecs")
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgservicefile"
+)
+
+// DefaultEnvPrefix is the prefix of the connector's environment variables read
+// by ConfigFromEnv when no prefix is given.
+const DefaultEnvPrefix = "DSQL"
+
+// clusterEndpointEnvVar is the variable the examples and integration tests
+// read the cluster endpoint from. ConfigFromEnv uses it when the prefixed HOST
+// variable is not set.
+const clusterEndpointEnvVar = "CLUSTER_ENDPOINT"
+
+// EnvSources records the environment variable that set each field of a Config
+// loaded by ConfigFromEnv, keyed by field name. Connection parameters are keyed
+// as Params["name"]. Fields set from a service file are attributed to PGSERVICE.
+type EnvSources map[string]string
+
+// connectorEnvVars maps the suffixes of the connector's prefixed environment
+// variables to connection string parameters.
+var connectorEnvVars = []struct {
+	suffix string
+	param  string
+}{
+	{"HOST", "host"},
+	{"PORT", "port"},
+	{"USER", "user"},
+	{"DATABASE", "dbname"},
+	{"REGION", "region"},
+	{"PROFILE", "profile"},
+	{"TOKEN_DURATION_SECS", "tokenDurationSecs"},
+	{"TOKEN_REFRESH_FRACTION", "tokenRefreshFraction"},
+	{"MAX_AUTH_RETRIES", "maxAuthRetries"},
+	{"DISABLE_AUTH_RETRY", "disableAuthRetry"},
+	{"CREDENTIAL_SOURCE", "credentialSource"},
+	{"WEB_IDENTITY_TOKEN_FILE", "webIdentityTokenFile"},
+	{"WEB_IDENTITY_ROLE_ARN", "webIdentityRoleArn"},
+	{"ASSUME_ROLE_ARN", "assumeRoleArn"},
+	{"TOKEN_ACTION", "tokenAction"},
+	{"CLOCK_SKEW_COMPENSATION", "clockSkewCompensation"},
+	{"SERVICE_NAME", "serviceName"},
+	{"DIAL_HOST", "dialHost"},
+	{"ENDPOINT_VARIANT", "endpointVariant"},
+}
+
+// libpqEnvVars maps the standard libpq environment variables to connection
+// string parameters. PGPASSWORD and PGPASSFILE are not read, since DSQL
+// authenticates with IAM tokens.
+var libpqEnvVars = []struct {
+	name  string
+	param string
+}{
+	{"PGHOST", "host"},
+	{"PGPORT", "port"},
+	{"PGUSER", "user"},
+	{"PGDATABASE", "dbname"},
+	{"PGAPPNAME", "application_name"},
+	{"PGCONNECT_TIMEOUT", "connect_timeout"},
+	{"PGSSLMODE", "sslmode"},
+	{"PGOPTIONS", "options"},
+	{"PGTZ", "timezone"},
+	{"PGDATESTYLE", "datestyle"},
+	{"PGCLIENTENCODING", "client_encoding"},
+}
+
+// paramFields maps connection string parameters to the Config fields they set.
+var paramFields = map[string]string{
+	"host":                    "Host",
+	"port":                    "Port",
+	"user":                    "User",
+	"dbname":                  "Database",
+	"region":                  "Region",
+	"profile":                 "Profile",
+	"tokenDurationSecs":       "TokenDurationSecs",
+	"tokenRefreshFraction":    "TokenRefreshFraction",
+	"maxAuthRetries":          "MaxAuthRetries",
+	"disableAuthRetry":        "DisableAuthRetry",
+	"credentialSource":        "CredentialSource",
+	"webIdentityTokenFile":    "WebIdentityTokenFile",
+	"webIdentityRoleArn":      "WebIdentityRoleARN",
+	"assumeRoleArn":           "AssumeRole",
+	"assumeRoleExternalId":    "AssumeRole",
+	"assumeRoleSessionName":   "AssumeRole",
+	"assumeRoleSessionTags":   "AssumeRole",
+	"assumeRoleDurationSecs":  "AssumeRole",
+	"assumeRoleSourceProfile": "AssumeRole",
+	"tokenAction":             "TokenAction",
+	"clockSkewCompensation":   "ClockSkewCompensation",
+	"serviceName":             "ServiceName",
+	"dialHost":                "DialHost",
+	"endpointVariant":         "EndpointVariant",
+}
+
+// ConfigFromEnv builds a Config from environment variables, and reports which
+// variable set each field.
+//
+// Variables are read in decreasing order of precedence:
+//
+//   - The connector's variables, named prefix + "_" + HOST, PORT, USER, DATABASE,
+//     REGION, PROFILE, TOKEN_DURATION_SECS, TOKEN_REFRESH_FRACTION,
+//     MAX_AUTH_RETRIES, DISABLE_AUTH_RETRY, CREDENTIAL_SOURCE,
+//     WEB_IDENTITY_TOKEN_FILE, WEB_IDENTITY_ROLE_ARN, ASSUME_ROLE_ARN (a
+//     comma-separated role chain), TOKEN_ACTION, CLOCK_SKEW_COMPENSATION,
+//     SERVICE_NAME, DIAL_HOST or ENDPOINT_VARIANT.
+//     The prefix defaults to DefaultEnvPrefix, giving DSQL_HOST and so on.
+//   - CLUSTER_ENDPOINT, for the host only.
+//   - The service named by prefix + "_SERVICE" or PGSERVICE, read from the
+//     file named by PGSERVICEFILE or ~/.pg_service.conf, then from
+//     pg_service.conf in PGSYSCONFDIR. Services may use any keyword accepted by
+//     ParseConnectionString.
+//   - The libpq variables PGHOST, PGPORT, PGUSER, PGDATABASE, PGAPPNAME,
+//     PGCONNECT_TIMEOUT, PGSSLMODE, PGOPTIONS, PGTZ, PGDATESTYLE and
+//     PGCLIENTENCODING.
+//
+// AWS_REGION and AWS_DEFAULT_REGION are not read: as for any Config, NewPool
+// and Connect fall back to them when neither Region nor the host gives a region.
+//
+// Empty variables are ignored. The returned Config is not validated beyond
+// parsing; NewPool and Connect resolve it as usual.
+func ConfigFromEnv(prefix string) (*Config, EnvSources, error) {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	prefix = strings.TrimSuffix(prefix, "_") + "_"
+
+	params := make(url.Values)
+	sources := make(map[string]string)
+	set := func(param, value, source string) {
+		if value != "" {
+			params.Set(param, value)
+			sources[param] = source
+		}
+	}
+
+	// Apply sources from lowest to highest precedence. AWS_REGION and
+	// AWS_DEFAULT_REGION are not copied into Region, so that they do not take
+	// precedence over the region of the host when the config is resolved.
+	for _, v := range libpqEnvVars {
+		set(v.param, os.Getenv(v.name), v.name)
+	}
+
+	serviceVar := prefix + "SERVICE"
+	service := os.Getenv(serviceVar)
+	if service == "" {
+		serviceVar = "PGSERVICE"
+		service = os.Getenv(serviceVar)
+	}
+	if service != "" {
+		settings, err := lookupService(service)
+		if err != nil {
+			return nil, nil, err
+		}
+		for key, value := range settings {
+			if key == "database" {
+				key = "dbname"
+			}
+			set(key, value, serviceVar)
+		}
+	}
+
+	set("host", os.Getenv(clusterEndpointEnvVar), clusterEndpointEnvVar)
+
+	for _, v := range connectorEnvVars {
+		name := prefix + v.suffix
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if v.param == "assumeRoleArn" {
+			params.Del(v.param)
+			for _, arn := range strings.Split(value, ",") {
+				params.Add(v.param, strings.TrimSpace(arn))
+			}
+			sources[v.param] = name
+			continue
+		}
+		set(v.param, value, name)
+	}
+
+	cfg, err := configFromKeywords(params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envSources := make(EnvSources, len(sources))
+	for param, source := range sources {
+		field, ok := paramFields[param]
+		if !ok {
+			field = fmt.Sprintf("Params[%q]", param)
+		}
+		envSources[field] = source
+	}
+	return cfg, envSources, nil
+}
+
+// lookupService returns the settings of the named service from the user's
+// service file, or failing that the system-wide one, as libpq does.
+func lookupService(name string) (map[string]string, error) {
+	var paths []string
+	if path := os.Getenv("PGSERVICEFILE"); path != "" {
+		paths = append(paths, path)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".pg_service.conf"))
+	}
+	if dir := os.Getenv("PGSYSCONFDIR"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "pg_service.conf"))
+	}
+
+	for _, path := range paths {
+		servicefile, err := pgservicefile.ReadServicefile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, configErrorf("unable to read service file %s: %w", path, err)
+		}
+		if service, err := servicefile.GetService(name); err == nil {
+			return service.Settings, nil
+		}
+	}
+	return nil, configErrorf("service %q not found", name)
+}
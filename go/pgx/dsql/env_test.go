@@ -0,0 +1,215 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clearConfigEnv unsets every variable read by ConfigFromEnv for the test.
+func clearConfigEnv(t *testing.T, prefix string) {
+	t.Helper()
+	names := []string{"AWS_REGION", "AWS_DEFAULT_REGION", "PGSERVICE", "PGSYSCONFDIR", prefix + "_SERVICE", clusterEndpointEnvVar}
+	for _, v := range libpqEnvVars {
+		names = append(names, v.name)
+	}
+	for _, v := range connectorEnvVars {
+		names = append(names, prefix+"_"+v.suffix)
+	}
+	for _, name := range names {
+		t.Setenv(name, "")
+	}
+	// Keep the user's service file out of the test.
+	t.Setenv("PGSERVICEFILE", filepath.Join(t.TempDir(), "missing.conf"))
+}
+
+func TestConfigFromEnvEmpty(t *testing.T) {
+	clearConfigEnv(t, DefaultEnvPrefix)
+
+	cfg, sources, err := ConfigFromEnv("")
+	require.NoError(t, err)
+	assert.Equal(t, &Config{}, cfg)
+	assert.Empty(t, sources)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	clearConfigEnv(t, DefaultEnvPrefix)
+	t.Setenv("AWS_REGION", "us-west-2")
+	t.Setenv("PGHOST", "pg.dsql.us-east-1.on.aws")
+	t.Setenv("PGUSER", "reader")
+	t.Setenv("PGDATABASE", "orders")
+	t.Setenv("PGAPPNAME", "orders-svc")
+	t.Setenv("PGPASSWORD", "ignored")
+	t.Setenv("DSQL_HOST", "mycluster.dsql.us-east-1.on.aws")
+	t.Setenv("DSQL_PORT", "5433")
+	t.Setenv("DSQL_PROFILE", "dev")
+	t.Setenv("DSQL_TOKEN_DURATION_SECS", "600")
+	t.Setenv("DSQL_CLOCK_SKEW_COMPENSATION", "true")
+	t.Setenv("DSQL_ASSUME_ROLE_ARN", "arn:aws:iam::111111111111:role/A, arn:aws:iam::222222222222:role/B")
+
+	cfg, sources, err := ConfigFromEnv("")
+	require.NoError(t, err)
+	assert.Equal(t, &Config{
+		Host:                  "mycluster.dsql.us-east-1.on.aws",
+		User:                  "reader",
+		Database:              "orders",
+		Port:                  5433,
+		Profile:               "dev",
+		TokenDurationSecs:     600,
+		ClockSkewCompensation: true,
+		AssumeRole: []AssumeRoleOptions{
+			{RoleARN: "arn:aws:iam::111111111111:role/A"},
+			{RoleARN: "arn:aws:iam::222222222222:role/B"},
+		},
+		Params: map[string]string{"application_name": "orders-svc"},
+	}, cfg)
+	assert.Equal(t, EnvSources{
+		"Host":                       "DSQL_HOST",
+		"User":                       "PGUSER",
+		"Database":                   "PGDATABASE",
+		"Port":                       "DSQL_PORT",
+		"Profile":                    "DSQL_PROFILE",
+		"TokenDurationSecs":          "DSQL_TOKEN_DURATION_SECS",
+		"ClockSkewCompensation":      "DSQL_CLOCK_SKEW_COMPENSATION",
+		"AssumeRole":                 "DSQL_ASSUME_ROLE_ARN",
+		`Params["application_name"]`: "PGAPPNAME",
+	}, sources)
+}
+
+func TestConfigFromEnvAWSRegion(t *testing.T) {
+	clearConfigEnv(t, DefaultEnvPrefix)
+	t.Setenv("AWS_REGION", "us-west-2")
+
+	// The region of the endpoint takes precedence over AWS_REGION.
+	t.Setenv("DSQL_HOST", testHost)
+	cfg, sources, err := ConfigFromEnv("")
+	require.NoError(t, err)
+	assert.Equal(t, EnvSources{"Host": "DSQL_HOST"}, sources)
+	resolved, err := cfg.resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "us-east-1", resolved.Region)
+
+	// AWS_REGION supplies the region of a cluster ID.
+	t.Setenv("DSQL_HOST", "ijsamhssbh36dopuigphknejb4")
+	cfg, _, err = ConfigFromEnv("")
+	require.NoError(t, err)
+	resolved, err = cfg.resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "us-west-2", resolved.Region)
+}
+
+func TestConfigFromEnvClusterEndpoint(t *testing.T) {
+	clearConfigEnv(t, DefaultEnvPrefix)
+	t.Setenv("PGHOST", "pg.dsql.us-east-1.on.aws")
+	t.Setenv("CLUSTER_ENDPOINT", testHost)
+
+	// CLUSTER_ENDPOINT takes precedence over PGHOST.
+	cfg, sources, err := ConfigFromEnv("")
+	require.NoError(t, err)
+	assert.Equal(t, testHost, cfg.Host)
+	assert.Equal(t, EnvSources{"Host": "CLUSTER_ENDPOINT"}, sources)
+
+	// DSQL_HOST takes precedence over CLUSTER_ENDPOINT.
+	t.Setenv("DSQL_HOST", "mycluster.dsql.us-east-1.on.aws")
+	cfg, sources, err = ConfigFromEnv("")
+	require.NoError(t, err)
+	assert.Equal(t, "mycluster.dsql.us-east-1.on.aws", cfg.Host)
+	assert.Equal(t, EnvSources{"Host": "DSQL_HOST"}, sources)
+}
+
+func TestConfigFromEnvPrefix(t *testing.T) {
+	clearConfigEnv(t, "ORDERS")
+	t.Setenv("ORDERS_HOST", "ijsamhssbh36dopuigphknejb4")
+	t.Setenv("ORDERS_REGION", "us-east-1")
+	t.Setenv("AWS_DEFAULT_REGION", "eu-west-1")
+
+	cfg, sources, err := ConfigFromEnv("ORDERS_")
+	require.NoError(t, err)
+	assert.Equal(t, &Config{Host: "ijsamhssbh36dopuigphknejb4", Region: "us-east-1"}, cfg)
+	assert.Equal(t, EnvSources{"Host": "ORDERS_HOST", "Region": "ORDERS_REGION"}, sources)
+}
+
+func TestConfigFromEnvService(t *testing.T) {
+	clearConfigEnv(t, DefaultEnvPrefix)
+
+	userDir := t.TempDir()
+	userFile := filepath.Join(userDir, "services.conf")
+	require.NoError(t, os.WriteFile(userFile, []byte(`
+# user services
+[orders]
+host=orders.dsql.us-east-1.on.aws
+user=orders_rw
+database=orders
+search_path=app
+`), 0o600))
+
+	sysDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(sysDir, "pg_service.conf"), []byte(`
+[reporting]
+host=reports.dsql.us-west-2.on.aws
+tokenAction=standard
+`), 0o600))
+
+	t.Setenv("PGSERVICEFILE", userFile)
+	t.Setenv("PGSYSCONFDIR", sysDir)
+	t.Setenv("PGUSER", "overridden")
+	t.Setenv("PGSERVICE", "orders")
+	t.Setenv("DSQL_USER", "admin")
+
+	cfg, sources, err := ConfigFromEnv("")
+	require.NoError(t, err)
+	assert.Equal(t, &Config{
+		Host:     "orders.dsql.us-east-1.on.aws",
+		User:     "admin",
+		Database: "orders",
+		Params:   map[string]string{"search_path": "app"},
+	}, cfg)
+	assert.Equal(t, EnvSources{
+		"Host":                  "PGSERVICE",
+		"User":                  "DSQL_USER",
+		"Database":              "PGSERVICE",
+		`Params["search_path"]`: "PGSERVICE",
+	}, sources)
+
+	// The system-wide file is searched when the user file lacks the service.
+	t.Setenv("DSQL_SERVICE", "reporting")
+	cfg, sources, err = ConfigFromEnv("")
+	require.NoError(t, err)
+	assert.Equal(t, "reports.dsql.us-west-2.on.aws", cfg.Host)
+	assert.Equal(t, TokenActionStandard, cfg.TokenAction)
+	assert.Equal(t, "DSQL_SERVICE", sources["TokenAction"])
+
+	t.Setenv("DSQL_SERVICE", "missing")
+	_, _, err = ConfigFromEnv("")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), `service "missing" not found`)
+}
+
+func TestConfigFromEnvInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"DSQL_PORT", "not-a-port"},
+		{"DSQL_TOKEN_DURATION_SECS", "soon"},
+		{"PGSSLMODE", "disable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t, DefaultEnvPrefix)
+			t.Setenv(tt.name, tt.value)
+
+			_, _, err := ConfigFromEnv("")
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+		})
+	}
+}
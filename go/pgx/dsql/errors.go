@@ -0,0 +1,147 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrInvalidConfig is matched by errors caused by an invalid Config or connection
+// string. Use errors.Is(err, dsql.ErrInvalidConfig) to detect them.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// PostgreSQL error codes returned when the server rejects authentication.
+const (
+	// ErrorCodeInvalidAuthorization is returned for invalid_authorization_specification.
+	ErrorCodeInvalidAuthorization = "28000"
+
+	// ErrorCodeInvalidPassword is returned for invalid_password, which for DSQL
+	// means the IAM token was rejected.
+	ErrorCodeInvalidPassword = "28P01"
+)
+
+// invalidConfigError keeps the original message of a configuration error
+// while matching ErrInvalidConfig.
+type invalidConfigError struct {
+	err error
+}
+
+func (e *invalidConfigError) Error() string { return e.err.Error() }
+
+func (e *invalidConfigError) Unwrap() []error { return []error{ErrInvalidConfig, e.err} }
+
+// configErrorf returns a configuration error that matches ErrInvalidConfig.
+func configErrorf(format string, args ...any) error {
+	return &invalidConfigError{err: fmt.Errorf(format, args...)}
+}
+
+// FieldError is a configuration error for one Config field, returned by
+// Config.Validate. It matches ErrInvalidConfig.
+type FieldError struct {
+	// Field is the name of the Config field, such as "TokenDurationSecs" or
+	// "AssumeRole[1]".
+	Field string
+
+	// Err describes the problem.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() []error { return []error{ErrInvalidConfig, e.Err} }
+
+// CredentialsError is returned when AWS credentials cannot be resolved or retrieved.
+type CredentialsError struct {
+	// Source is the credential source in use, if known.
+	Source CredentialSource
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *CredentialsError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("credentials error: %v", e.Err)
+	}
+	return fmt.Sprintf("%s credentials error: %v", e.Source, e.Err)
+}
+
+func (e *CredentialsError) Unwrap() error { return e.Err }
+
+// TokenError is returned when an authentication token cannot be generated.
+type TokenError struct {
+	Host   string
+	Region string
+	User   string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("failed to generate auth token: %v", e.Err)
+}
+
+func (e *TokenError) Unwrap() error { return e.Err }
+
+// AuthError is returned when the server rejects a connection's authentication,
+// for example because the token was stale, signed with a skewed clock, or
+// issued for credentials that were revoked. The wrapped error chain contains the
+// server's *pgconn.PgError with code 28000 or 28P01.
+type AuthError struct {
+	Host string
+	User string
+
+	// ClockSkew is the measured offset of the local clock from the reference
+	// clock, positive when the local clock is behind. It is zero unless clock skew
+	// compensation is enabled and a significant skew was measured.
+	ClockSkew time.Duration
+
+	// Err is the underlying connection error.
+	Err error
+}
+
+// newAuthError returns an *AuthError for err, recording the skew measured by clock.
+func newAuthError(resolved *resolvedConfig, clock *skewClock, err error) *AuthError {
+	authErr := &AuthError{Host: resolved.Host, User: resolved.User, Err: err}
+	if clock != nil {
+		if skew, ok := clock.Skew(); ok && (skew >= minClockSkew || skew <= -minClockSkew) {
+			authErr.ClockSkew = skew
+		}
+	}
+	return authErr
+}
+
+func (e *AuthError) Error() string {
+	if e.ClockSkew != 0 {
+		return fmt.Sprintf("authentication failed for user %q on %s (local clock skew %s): %v", e.User, e.Host, e.ClockSkew, e.Err)
+	}
+	return fmt.Sprintf("authentication failed for user %q on %s: %v", e.User, e.Host, e.Err)
+}
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// IsAuthError reports whether err is an authentication rejection, either an
+// *AuthError or a *pgconn.PgError with code 28000 or 28P01. Connect and pools
+// created by this package report rejections as *AuthError; IsAuthError also
+// detects them in errors from connections made some other way.
+func IsAuthError(err error) bool {
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == ErrorCodeInvalidAuthorization || pgErr.Code == ErrorCodeInvalidPassword
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  func() error
+		msg  string
+	}{
+		{
+			name: "missing host",
+			err: func() error {
+				_, err := (&Config{}).resolve()
+				return err
+			},
+			msg: "host is required",
+		},
+		{
+			name: "invalid port in connection string",
+			err: func() error {
+				_, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws:abc/postgres")
+				return err
+			},
+			msg: "invalid connection string",
+		},
+		{
+			name: "nil config",
+			err: func() error {
+				_, err := Connect(context.Background(), (*Config)(nil))
+				return err
+			},
+			msg: "config cannot be nil",
+		},
+		{
+			name: "unsupported config type",
+			err: func() error {
+				_, err := NewPool(context.Background(), 42)
+				return err
+			},
+			msg: "config must be Config, *Config, or string, got int",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.err()
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+			assert.Contains(t, err.Error(), tt.msg)
+		})
+	}
+}
+
+func TestGenerateTokenCredentialsError(t *testing.T) {
+	retrieveErr := errors.New("no EC2 IMDS role found")
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{}, retrieveErr
+	})
+
+	_, err := GenerateToken(context.Background(), testHost, "us-east-1", "admin", provider, 0)
+	require.Error(t, err)
+
+	var credsErr *CredentialsError
+	require.ErrorAs(t, err, &credsErr)
+	assert.Equal(t, CredentialSourceCustom, credsErr.Source)
+	assert.ErrorIs(t, err, retrieveErr)
+
+	var tokenErr *TokenError
+	assert.False(t, errors.As(err, &tokenErr))
+}
+
+func TestGenerateTokenTokenError(t *testing.T) {
+	var calls atomic.Int32
+	_, err := GenerateToken(context.Background(), testHost, "", "admin", countingCredentials(&calls), 0)
+	require.Error(t, err)
+
+	var tokenErr *TokenError
+	require.ErrorAs(t, err, &tokenErr)
+	assert.Equal(t, testHost, tokenErr.Host)
+	assert.Equal(t, "admin", tokenErr.User)
+	assert.Contains(t, err.Error(), "failed to generate auth token")
+}
+
+func TestIsAuthError(t *testing.T) {
+	invalidPassword := &pgconn.PgError{Code: ErrorCodeInvalidPassword, Message: "access denied"}
+	invalidAuth := &pgconn.PgError{Code: ErrorCodeInvalidAuthorization, Message: "unable to accept connection"}
+	other := &pgconn.PgError{Code: "3D000", Message: "database does not exist"}
+
+	assert.True(t, IsAuthError(invalidPassword))
+	assert.True(t, IsAuthError(fmt.Errorf("failed to connect: %w", invalidAuth)))
+	assert.False(t, IsAuthError(other))
+	assert.False(t, IsAuthError(errors.New("connection refused")))
+	assert.False(t, IsAuthError(nil))
+
+	authErr := &AuthError{Host: testHost, User: "admin", Err: fmt.Errorf("failed to connect: %w", invalidPassword)}
+	assert.True(t, IsAuthError(authErr))
+
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, authErr, &pgErr)
+	assert.Equal(t, ErrorCodeInvalidPassword, pgErr.Code)
+	assert.Contains(t, authErr.Error(), `authentication failed for user "admin"`)
+}
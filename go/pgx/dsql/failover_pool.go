@@ -0,0 +1,469 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Failover defaults for NewFailoverPool.
+const (
+	// DefaultFailoverCheckInterval is how often every region of a FailoverPool
+	// is health-checked.
+	DefaultFailoverCheckInterval = 5 * time.Second
+
+	// DefaultFailoverThreshold is the number of consecutive connect or
+	// health-check failures after which a region is marked unhealthy.
+	DefaultFailoverThreshold = 2
+
+	// DefaultFailoverAcquireTimeout is how long Acquire waits for a region
+	// without a connect_timeout before trying the next region.
+	DefaultFailoverAcquireTimeout = 10 * time.Second
+)
+
+// FailoverPool is a set of connection pools to the regional endpoints of a
+// multi-region cluster. New connections go to the first healthy region in order
+// of preference, failing over to a peer region when the preferred region is
+// unhealthy and failing back once it recovers.
+//
+// Each region has its own pgxpool.Pool, credentials and token cache. A region is
+// marked unhealthy after DefaultFailoverThreshold consecutive failures to connect
+// or to pass a health check, and healthy again after one success. A region that
+// does not provide a connection within its connect_timeout, or
+// DefaultFailoverAcquireTimeout if none is set, has failed to connect. Failures
+// reported by the server, such as rejected authentication, do not count, since
+// they show that the region is reachable.
+type FailoverPool struct {
+	regions   []*failoverRegion
+	threshold int
+
+	stop chan struct{}
+	done chan struct{}
+
+	closed    atomic.Bool
+	closeOnce sync.Once
+}
+
+// failoverRegion is one regional endpoint of a FailoverPool.
+type failoverRegion struct {
+	region string
+	host   string
+	pool   *pgxpool.Pool
+
+	// acquireTimeout bounds an Acquire from pool when other regions remain
+	// to be tried.
+	acquireTimeout time.Duration
+
+	mu       sync.Mutex
+	healthy  bool
+	failures int
+	lastErr  error
+	since    time.Time
+}
+
+// RegionStatus reports the health of one region of a FailoverPool.
+type RegionStatus struct {
+	// Region is the AWS region of the endpoint.
+	Region string
+
+	// Host is the cluster endpoint in Region.
+	Host string
+
+	// Healthy reports whether new connections are sent to the region.
+	Healthy bool
+
+	// LastError is the most recent connect or health-check failure, or nil if
+	// the last attempt succeeded.
+	LastError error
+
+	// Since is when the region last changed between healthy and unhealthy, or
+	// when the pool was created.
+	Since time.Time
+}
+
+// NewFailoverPool creates a FailoverPool for the regional endpoints of a
+// multi-region cluster. primary is the preferred region, and peers are used in
+// the order given while it is unhealthy. Each Config is resolved and validated
+// as for NewPool, and every region must have a distinct endpoint.
+//
+// Creating the pool does not connect. Regions are health-checked every
+// DefaultFailoverCheckInterval until the pool is closed.
+func NewFailoverPool(ctx context.Context, primary Config, peers ...Config) (*FailoverPool, error) {
+	return newFailoverPool(ctx, DefaultFailoverCheckInterval, append([]Config{primary}, peers...))
+}
+
+func newFailoverPool(ctx context.Context, checkInterval time.Duration, configs []Config) (*FailoverPool, error) {
+	regions, err := newRegionPools(ctx, configs)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &FailoverPool{
+		regions:   regions,
+		threshold: DefaultFailoverThreshold,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.healthCheckLoop(checkInterval)
+	return p, nil
+}
+
+// newRegionPools resolves configs and creates a pool for each region. Every
+// region must have a distinct endpoint.
+func newRegionPools(ctx context.Context, configs []Config) ([]*failoverRegion, error) {
+	resolved := make([]*resolvedConfig, len(configs))
+	hosts := make(map[string]int, len(configs))
+	for i := range configs {
+		r, err := configs[i].resolve()
+		if err != nil {
+			return nil, fmt.Errorf("region %d: %w", i, err)
+		}
+		if j, ok := hosts[r.Host]; ok {
+			return nil, configErrorf("regions %d and %d have the same endpoint %s", j, i, r.Host)
+		}
+		hosts[r.Host] = i
+		resolved[i] = r
+	}
+
+	regions := make([]*failoverRegion, 0, len(resolved))
+	now := time.Now()
+	for _, r := range resolved {
+		pool, err := newPoolFromResolved(ctx, r, nil)
+		if err != nil {
+			closeRegionPools(regions)
+			return nil, fmt.Errorf("region %s: %w", r.Region, err)
+		}
+		acquireTimeout := pool.Config().ConnConfig.ConnectTimeout
+		if acquireTimeout <= 0 {
+			acquireTimeout = DefaultFailoverAcquireTimeout
+		}
+		regions = append(regions, &failoverRegion{
+			region:         r.Region,
+			host:           r.Host,
+			pool:           pool,
+			acquireTimeout: acquireTimeout,
+			healthy:        true,
+			since:          now,
+		})
+	}
+	return regions, nil
+}
+
+func closeRegionPools(regions []*failoverRegion) {
+	for _, r := range regions {
+		r.pool.Close()
+	}
+}
+
+// Acquire returns a connection from the first healthy region that accepts one.
+// If every region fails, the errors of all regions are returned.
+func (p *FailoverPool) Acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	if p.closed.Load() {
+		return nil, ErrPoolClosed
+	}
+	conn, _, err := acquireFrom(ctx, p.candidates(), p.threshold)
+	return conn, err
+}
+
+// acquireFrom returns a connection from the first of candidates that accepts
+// one, and its region, recording connect failures against threshold. Every
+// candidate but the last is given its acquireTimeout, so that a region that
+// hangs does not use up ctx before the others are tried.
+func acquireFrom(ctx context.Context, candidates []*failoverRegion, threshold int) (*pgxpool.Conn, *failoverRegion, error) {
+	var errs []error
+	for i, r := range candidates {
+		conn, err := r.acquire(ctx, i < len(candidates)-1)
+		if err == nil {
+			r.recordSuccess()
+			return conn, r, nil
+		}
+		if ctx.Err() != nil || !isRegionFailure(err) {
+			return nil, nil, err
+		}
+		r.recordFailure(err, threshold)
+		errs = append(errs, fmt.Errorf("region %s: %w", r.region, err))
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// acquire acquires a connection from r.pool, within r.acquireTimeout if bounded.
+func (r *failoverRegion) acquire(ctx context.Context, bounded bool) (*pgxpool.Conn, error) {
+	if !bounded || r.acquireTimeout <= 0 {
+		return r.pool.Acquire(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.acquireTimeout)
+	defer cancel()
+	return r.pool.Acquire(ctx)
+}
+
+// candidates returns the regions to try: healthy regions in order of
+// preference, then unhealthy regions as a last resort.
+func (p *FailoverPool) candidates() []*failoverRegion {
+	healthy := make([]*failoverRegion, 0, len(p.regions))
+	var unhealthy []*failoverRegion
+	for _, r := range p.regions {
+		if r.isHealthy() {
+			healthy = append(healthy, r)
+		} else {
+			unhealthy = append(unhealthy, r)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// isRegionFailure reports whether err means that a region could not be reached,
+// as opposed to an error reported by the server.
+func isRegionFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return !errors.As(err, &pgErr)
+}
+
+// Pool returns the pool of the first healthy region, or of the primary region
+// if none is healthy. Unlike Acquire, it does not fail over if connecting fails.
+func (p *FailoverPool) Pool() *pgxpool.Pool {
+	return p.candidates()[0].pool
+}
+
+// Status returns the health of each region, primary first.
+func (p *FailoverPool) Status() []RegionStatus {
+	status := make([]RegionStatus, len(p.regions))
+	for i, r := range p.regions {
+		status[i] = r.status()
+	}
+	return status
+}
+
+// Close stops health checks and closes the pool of every region.
+func (p *FailoverPool) Close() {
+	p.closeOnce.Do(func() {
+		p.closed.Store(true)
+		close(p.stop)
+		<-p.done
+		closeRegionPools(p.regions)
+	})
+}
+
+// Exec acquires a connection with failover and executes sql.
+func (p *FailoverPool) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	return execAcquired(ctx, p.Acquire, sql, arguments...)
+}
+
+// Query acquires a connection with failover and executes a query. The
+// connection is released when the rows are closed.
+func (p *FailoverPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return queryAcquired(ctx, p.Acquire, sql, args...)
+}
+
+// QueryRow acquires a connection with failover and executes a query that is
+// expected to return at most one row.
+func (p *FailoverPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return queryRowAcquired(ctx, p.Acquire, sql, args...)
+}
+
+// Begin acquires a connection with failover and starts a transaction. The
+// connection is released when the transaction is committed or rolled back.
+func (p *FailoverPool) Begin(ctx context.Context) (pgx.Tx, error) {
+	return beginAcquired(ctx, p.Acquire)
+}
+
+// acquireFunc acquires a connection, as FailoverPool.Acquire does.
+type acquireFunc func(ctx context.Context) (*pgxpool.Conn, error)
+
+// execAcquired executes sql on a connection from acquire.
+func execAcquired(ctx context.Context, acquire acquireFunc, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	conn, err := acquire(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer conn.Release()
+	return conn.Exec(ctx, sql, arguments...)
+}
+
+// queryAcquired executes a query on a connection from acquire, which is
+// released when the rows are closed.
+func queryAcquired(ctx context.Context, acquire acquireFunc, sql string, args ...any) (pgx.Rows, error) {
+	conn, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return &releasingRows{Rows: rows, conn: conn}, nil
+}
+
+// queryRowAcquired executes a query on a connection from acquire, which is
+// released after Scan.
+func queryRowAcquired(ctx context.Context, acquire acquireFunc, sql string, args ...any) pgx.Row {
+	conn, err := acquire(ctx)
+	if err != nil {
+		return errRow{err: err}
+	}
+	return &releasingRow{row: conn.QueryRow(ctx, sql, args...), conn: conn}
+}
+
+// beginAcquired starts a transaction on a connection from acquire, which is
+// released when the transaction ends.
+func beginAcquired(ctx context.Context, acquire acquireFunc) (pgx.Tx, error) {
+	conn, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return &releasingTx{Tx: tx, conn: conn}, nil
+}
+
+// healthCheckLoop checks every region each interval until the pool is closed.
+func (p *FailoverPool) healthCheckLoop(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			p.checkRegions(ctx)
+			cancel()
+		}
+	}
+}
+
+// checkRegions health-checks every region concurrently.
+func (p *FailoverPool) checkRegions(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range p.regions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// A health check that times out counts as a failure.
+			if err := r.pool.Ping(ctx); err == nil {
+				r.recordSuccess()
+			} else if isRegionFailure(err) {
+				r.recordFailure(err, p.threshold)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *failoverRegion) status() RegionStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RegionStatus{
+		Region:    r.region,
+		Host:      r.host,
+		Healthy:   r.healthy,
+		LastError: r.lastErr,
+		Since:     r.since,
+	}
+}
+
+func (r *failoverRegion) isHealthy() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.healthy
+}
+
+// recordSuccess marks r healthy.
+func (r *failoverRegion) recordSuccess() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = 0
+	r.lastErr = nil
+	if !r.healthy {
+		r.healthy = true
+		r.since = time.Now()
+	}
+}
+
+// recordFailure counts a failure, marking r unhealthy once threshold
+// consecutive failures are reached.
+func (r *failoverRegion) recordFailure(err error, threshold int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures++
+	r.lastErr = err
+	if r.healthy && r.failures >= threshold {
+		r.healthy = false
+		r.since = time.Now()
+	}
+}
+
+// releasingRows releases its connection when closed.
+type releasingRows struct {
+	pgx.Rows
+	conn *pgxpool.Conn
+}
+
+func (r *releasingRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.Close()
+	return false
+}
+
+func (r *releasingRows) Close() {
+	r.Rows.Close()
+	if r.conn != nil {
+		r.conn.Release()
+		r.conn = nil
+	}
+}
+
+// releasingRow releases its connection after Scan.
+type releasingRow struct {
+	row  pgx.Row
+	conn *pgxpool.Conn
+}
+
+func (r *releasingRow) Scan(dest ...any) error {
+	defer r.conn.Release()
+	return r.row.Scan(dest...)
+}
+
+// releasingTx releases its connection when the transaction ends.
+type releasingTx struct {
+	pgx.Tx
+	conn *pgxpool.Conn
+}
+
+func (tx *releasingTx) Commit(ctx context.Context) error {
+	err := tx.Tx.Commit(ctx)
+	tx.release()
+	return err
+}
+
+func (tx *releasingTx) Rollback(ctx context.Context) error {
+	err := tx.Tx.Rollback(ctx)
+	tx.release()
+	return err
+}
+
+func (tx *releasingTx) release() {
+	if tx.conn != nil {
+		tx.conn.Release()
+		tx.conn = nil
+	}
+}
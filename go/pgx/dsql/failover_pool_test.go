@@ -0,0 +1,216 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegion is a regional endpoint served by a minimal PostgreSQL server that
+// accepts any startup over TLS and answers every simple query after delay.
+type fakeRegion struct {
+	down  atomic.Bool
+	hang  atomic.Bool
+	dials atomic.Int32
+	delay atomic.Int64
+}
+
+// newFakeRegion starts a server for host and returns a Config that connects to
+// it unless the region is down.
+func newFakeRegion(t *testing.T, host string) (*fakeRegion, Config) {
+	pki := newTestPKI(t, host)
+	region := &fakeRegion{}
+	addr := serve(t, func(conn net.Conn) {
+		servePostgres(conn, &tls.Config{Certificates: []tls.Certificate{pki.server}}, &region.delay)
+	})
+
+	return region, Config{
+		Host:          host,
+		TLS:           &TLSConfig{RootCAs: pki.pool()},
+		TokenProvider: &staticTokenProvider{token: "token"},
+		Dialer: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			region.dials.Add(1)
+			if region.down.Load() {
+				return nil, errors.New("connection refused")
+			}
+			if region.hang.Load() {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+}
+
+func servePostgres(conn net.Conn, tlsConfig *tls.Config, delay *atomic.Int64) {
+	msg, err := pgproto3.NewBackend(conn, conn).ReceiveStartupMessage()
+	if _, ok := msg.(*pgproto3.SSLRequest); err != nil || !ok {
+		return
+	}
+	if _, err := conn.Write([]byte("S")); err != nil {
+		return
+	}
+	tlsConn := tls.Server(conn, tlsConfig)
+	backend := pgproto3.NewBackend(tlsConn, tlsConn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if backend.Flush() != nil {
+		return
+	}
+
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		query, ok := msg.(*pgproto3.Query)
+		if !ok {
+			return
+		}
+		time.Sleep(time.Duration(delay.Load()))
+		if strings.HasPrefix(query.String, "--") {
+			backend.Send(&pgproto3.EmptyQueryResponse{})
+		} else {
+			backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+		}
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		if backend.Flush() != nil {
+			return
+		}
+	}
+}
+
+// acquiredHost returns the cluster host of a connection acquired from p.
+func acquiredHost(t *testing.T, p *FailoverPool) string {
+	t.Helper()
+	conn, err := p.Acquire(context.Background())
+	require.NoError(t, err)
+	defer conn.Release()
+	return conn.Conn().Config().TLSConfig.ServerName
+}
+
+func TestFailoverPool(t *testing.T) {
+	const (
+		eastHost = "ijsamhssbh36dopuigphknejb4.dsql.us-east-1.on.aws"
+		westHost = "ijsamhssbh36dopuigphknejb4.dsql.us-west-2.on.aws"
+	)
+	east, eastConfig := newFakeRegion(t, eastHost)
+	west, westConfig := newFakeRegion(t, westHost)
+
+	// Health checks are run by hand.
+	pool, err := newFailoverPool(context.Background(), time.Hour, []Config{eastConfig, westConfig})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	assert.Equal(t, eastHost, acquiredHost(t, pool))
+	assert.Zero(t, west.dials.Load())
+
+	// A failed connect fails over to the peer, and marks the primary unhealthy
+	// once the threshold is reached.
+	east.down.Store(true)
+	pool.regions[0].pool.Reset()
+	assert.Equal(t, westHost, acquiredHost(t, pool))
+	assert.True(t, pool.Status()[0].Healthy)
+	pool.regions[0].pool.Reset()
+	assert.Equal(t, westHost, acquiredHost(t, pool))
+
+	status := pool.Status()
+	assert.False(t, status[0].Healthy)
+	assert.ErrorContains(t, status[0].LastError, "connection refused")
+	assert.True(t, status[1].Healthy)
+	assert.Equal(t, "us-west-2", status[1].Region)
+
+	// The unhealthy primary is skipped.
+	dials := east.dials.Load()
+	assert.Equal(t, westHost, acquiredHost(t, pool))
+	assert.Equal(t, dials, east.dials.Load())
+	assert.Same(t, pool.regions[1].pool, pool.Pool())
+
+	// Queries fail over too.
+	tag, err := pool.Exec(context.Background(), "select 1")
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 1", tag.String())
+
+	// Once the primary passes a health check, connections fail back to it.
+	pool.checkRegions(context.Background())
+	assert.False(t, pool.Status()[0].Healthy)
+	east.down.Store(false)
+	pool.checkRegions(context.Background())
+	assert.True(t, pool.Status()[0].Healthy)
+	assert.Nil(t, pool.Status()[0].LastError)
+	assert.Equal(t, eastHost, acquiredHost(t, pool))
+}
+
+func TestFailoverPoolHungRegion(t *testing.T) {
+	const westHost = "ijsamhssbh36dopuigphknejb4.dsql.us-west-2.on.aws"
+	east, eastConfig := newFakeRegion(t, "ijsamhssbh36dopuigphknejb4.dsql.us-east-1.on.aws")
+	_, westConfig := newFakeRegion(t, westHost)
+	westConfig.Params = map[string]string{"connect_timeout": "3"}
+	east.hang.Store(true)
+
+	pool, err := newFailoverPool(context.Background(), time.Hour, []Config{eastConfig, westConfig})
+	require.NoError(t, err)
+	defer pool.Close()
+	assert.Equal(t, DefaultFailoverAcquireTimeout, pool.regions[0].acquireTimeout)
+	assert.Equal(t, 3*time.Second, pool.regions[1].acquireTimeout)
+
+	// The hung primary is abandoned after its timeout, well before the
+	// caller's deadline, and counts as a connect failure.
+	pool.regions[0].acquireTimeout = 50 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+	assert.Equal(t, westHost, conn.Conn().Config().TLSConfig.ServerName)
+	assert.ErrorIs(t, pool.Status()[0].LastError, context.DeadlineExceeded)
+}
+
+func TestFailoverPoolAllRegionsDown(t *testing.T) {
+	east, eastConfig := newFakeRegion(t, "ijsamhssbh36dopuigphknejb4.dsql.us-east-1.on.aws")
+	west, westConfig := newFakeRegion(t, "ijsamhssbh36dopuigphknejb4.dsql.us-west-2.on.aws")
+	east.down.Store(true)
+	west.down.Store(true)
+
+	pool, err := newFailoverPool(context.Background(), time.Hour, []Config{eastConfig, westConfig})
+	require.NoError(t, err)
+
+	_, err = pool.Acquire(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "region us-east-1:")
+	assert.Contains(t, err.Error(), "region us-west-2:")
+
+	pool.Close()
+	_, err = pool.Acquire(context.Background())
+	assert.ErrorIs(t, err, ErrPoolClosed)
+}
+
+func TestNewFailoverPoolErrors(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Host: testHost, TokenProvider: &staticTokenProvider{token: "t"}}
+
+	_, err := NewFailoverPool(ctx, cfg, Config{Host: "ijsamhssbh36dopuigphknejb4", Region: "us-east-1"})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "regions 0 and 1 have the same endpoint")
+
+	_, err = NewFailoverPool(ctx, cfg, Config{})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "region 1:")
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import "container/list"
+
+// lruMap is a map holding at most a fixed number of entries. Adding an entry
+// to a full map evicts the least recently used one.
+//
+// An lruMap is not safe for concurrent use.
+type lruMap[K comparable, V any] struct {
+	capacity int
+	order    *list.List // of *lruEntry[K, V], most recently used first
+	entries  map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// newLRUMap creates an lruMap holding at most capacity entries.
+func newLRUMap[K comparable, V any](capacity int) *lruMap[K, V] {
+	return &lruMap[K, V]{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[K]*list.Element),
+	}
+}
+
+// get returns the value for key and marks it as most recently used.
+func (m *lruMap[K, V]) get(key K) (V, bool) {
+	elem, ok := m.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	m.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[K, V]).value, true
+}
+
+// add stores value for key as the most recently used entry. If this evicts
+// another entry, its value is returned so that the caller can release it.
+func (m *lruMap[K, V]) add(key K, value V) (evicted V, ok bool) {
+	if elem, found := m.entries[key]; found {
+		elem.Value.(*lruEntry[K, V]).value = value
+		m.order.MoveToFront(elem)
+		return evicted, false
+	}
+
+	m.entries[key] = m.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	if m.order.Len() <= m.capacity {
+		return evicted, false
+	}
+
+	oldest := m.order.Back()
+	m.order.Remove(oldest)
+	entry := oldest.Value.(*lruEntry[K, V])
+	delete(m.entries, entry.key)
+	return entry.value, true
+}
+
+// len returns the number of entries.
+func (m *lruMap[K, V]) len() int {
+	return m.order.Len()
+}
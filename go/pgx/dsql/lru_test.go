@@ -0,0 +1,42 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUMap(t *testing.T) {
+	m := newLRUMap[string, int](2)
+
+	_, evicted := m.add("a", 1)
+	assert.False(t, evicted)
+	_, evicted = m.add("b", 2)
+	assert.False(t, evicted)
+
+	// Reading "a" makes "b" the least recently used entry.
+	value, ok := m.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	value, evicted = m.add("c", 3)
+	assert.True(t, evicted)
+	assert.Equal(t, 2, value)
+	assert.Equal(t, 2, m.len())
+
+	_, ok = m.get("b")
+	assert.False(t, ok)
+
+	// Replacing a value does not evict anything.
+	_, evicted = m.add("a", 10)
+	assert.False(t, evicted)
+	value, ok = m.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 10, value)
+	assert.Equal(t, 2, m.len())
+}
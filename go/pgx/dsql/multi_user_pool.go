@@ -0,0 +1,350 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// evictRetryInterval is how often a connection attempt waiting for a free slot
+// under the global connection cap retries evicting an idle connection.
+const evictRetryInterval = 50 * time.Millisecond
+
+// ErrPoolClosed is returned when acquiring a connection from a closed
+// MultiUserPool or FailoverPool.
+var ErrPoolClosed = errors.New("dsql: pool is closed")
+
+// MultiUserPool is a set of connection pools to one cluster, one per database
+// role, sharing AWS credentials, a token cache and a global connection cap.
+//
+// Sub-pools are created on first use of a role. Each token authorizes the IAM
+// action for its role: DbConnectAdmin for "admin" and DbConnect for every other
+// role. When the cap is reached,
+// a new connection for one role evicts an idle connection of any role, or waits
+// for one to become idle.
+type MultiUserPool struct {
+	resolved      *resolvedConfig
+	template      *pgxpool.Config
+	tokenProvider TokenProvider
+	clock         *skewClock
+	limiter       *connLimiter
+
+	mu     sync.Mutex
+	pools  map[string]*pgxpool.Pool
+	closed bool
+}
+
+// NewMultiUserPool creates a MultiUserPool for the cluster in config. Config.User
+// is the role used when no role is given through WithRole.
+//
+// The config parameter can be a Config struct, *Config, or a connection string.
+//
+// The optional poolConfig parameter is the template for each role's sub-pool,
+// as for NewPool. Its MaxConns is the cap on the total number of connections
+// across all roles. MinConns and MinIdleConns must be 0, since idle connections
+// held for every role would defeat the cap, and Config.TokenAction must be
+// TokenActionAuto, since one action cannot suit every role.
+func NewMultiUserPool(ctx context.Context, config any, poolConfig ...*pgxpool.Config) (*MultiUserPool, error) {
+	cfg, err := toConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TokenAction != TokenActionAuto {
+		return nil, configErrorf("token action %q is not supported by MultiUserPool, which selects the action for each role", cfg.TokenAction)
+	}
+
+	resolved, err := cfg.resolve()
+	if err != nil {
+		return nil, err
+	}
+	// Sub-pools copy resolved, sharing the root certificates loaded here.
+	if err := resolved.loadTLSConfig(); err != nil {
+		return nil, err
+	}
+
+	var template *pgxpool.Config
+	if len(poolConfig) > 0 && poolConfig[0] != nil {
+		template = poolConfig[0].Copy()
+	}
+
+	// Without a template, the sizing comes from the pool_* connection parameters.
+	sizing := template
+	if sizing == nil {
+		sizing, err = pgxpool.ParseConfig(paramsDSN(resolved.Params, true))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create pool config: %w", err)
+		}
+	}
+	if sizing.MinConns > 0 || sizing.MinIdleConns > 0 {
+		return nil, configErrorf("MinConns and MinIdleConns are not supported by MultiUserPool, got %d and %d", sizing.MinConns, sizing.MinIdleConns)
+	}
+
+	maxConns := sizing.MaxConns
+	if maxConns <= 0 {
+		defaults, err := pgxpool.ParseConfig(paramsDSN(resolved.Params, true))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create pool config: %w", err)
+		}
+		maxConns = defaults.MaxConns
+	}
+
+	var clock *skewClock
+	if resolved.compensatesSkew() {
+		clock = newSkewClock()
+	}
+
+	// A single token cache serves every role: its entries are keyed by user.
+	tokenProvider, err := poolTokenProvider(ctx, resolved, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MultiUserPool{
+		resolved:      resolved,
+		template:      template,
+		tokenProvider: tokenProvider,
+		clock:         clock,
+		pools:         make(map[string]*pgxpool.Pool),
+	}
+	p.limiter = newConnLimiter(maxConns, p.evictIdle)
+	return p, nil
+}
+
+// Pool returns the sub-pool for role, creating it if needed.
+func (p *MultiUserPool) Pool(role string) (*pgxpool.Pool, error) {
+	if strings.TrimSpace(role) == "" {
+		return nil, configErrorf("role must not be empty")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	if pool, ok := p.pools[role]; ok {
+		return pool, nil
+	}
+
+	resolved := *p.resolved
+	resolved.User = role
+
+	var template *pgxpool.Config
+	if p.template != nil {
+		template = p.template.Copy()
+	}
+	poolConfig, err := resolved.configurePoolConfig(template, p.tokenProvider, p.clock)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.MaxConns = p.limiter.max
+	poolConfig.AfterConnect = p.limiter.afterConnect(poolConfig.AfterConnect)
+
+	// Creating a pool does not connect, since MinConns is 0.
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create connection pool for role %q: %w", role, err)
+	}
+	p.pools[role] = pool
+	return pool, nil
+}
+
+// Acquire returns a connection authenticated as role.
+func (p *MultiUserPool) Acquire(ctx context.Context, role string) (*pgxpool.Conn, error) {
+	pool, err := p.Pool(role)
+	if err != nil {
+		return nil, err
+	}
+	return pool.Acquire(ctx)
+}
+
+// Close closes every sub-pool.
+func (p *MultiUserPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	pools := p.pools
+	p.pools = make(map[string]*pgxpool.Pool)
+	p.mu.Unlock()
+
+	for _, pool := range pools {
+		pool.Close()
+	}
+}
+
+// TotalConns returns the number of open connections across all roles.
+func (p *MultiUserPool) TotalConns() int32 {
+	return int32(len(p.limiter.slots))
+}
+
+// Exec acquires a connection for the role in ctx and executes sql.
+func (p *MultiUserPool) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	pool, err := p.Pool(p.roleFromContext(ctx))
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	return pool.Exec(ctx, sql, arguments...)
+}
+
+// Query acquires a connection for the role in ctx and executes a query.
+func (p *MultiUserPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	pool, err := p.Pool(p.roleFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return pool.Query(ctx, sql, args...)
+}
+
+// QueryRow acquires a connection for the role in ctx and executes a query that
+// is expected to return at most one row.
+func (p *MultiUserPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	pool, err := p.Pool(p.roleFromContext(ctx))
+	if err != nil {
+		return errRow{err: err}
+	}
+	return pool.QueryRow(ctx, sql, args...)
+}
+
+// Begin acquires a connection for the role in ctx and starts a transaction.
+func (p *MultiUserPool) Begin(ctx context.Context) (pgx.Tx, error) {
+	pool, err := p.Pool(p.roleFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return pool.Begin(ctx)
+}
+
+// roleFromContext returns the role set with WithRole, or Config.User.
+func (p *MultiUserPool) roleFromContext(ctx context.Context) string {
+	if role, ok := RoleFromContext(ctx); ok {
+		return role
+	}
+	return p.resolved.User
+}
+
+// evictIdle closes one idle connection of any role, if there is one, to free a
+// slot under the global cap.
+func (p *MultiUserPool) evictIdle(ctx context.Context) {
+	p.mu.Lock()
+	pools := make([]*pgxpool.Pool, 0, len(p.pools))
+	for _, pool := range p.pools {
+		pools = append(pools, pool)
+	}
+	p.mu.Unlock()
+
+	for _, pool := range pools {
+		idle := pool.AcquireAllIdle(ctx)
+		if len(idle) == 0 {
+			continue
+		}
+		// Hijacking removes the connection from its pool before it is closed.
+		_ = idle[0].Hijack().Close(ctx)
+		for _, conn := range idle[1:] {
+			conn.Release()
+		}
+		return
+	}
+}
+
+type roleContextKey struct{}
+
+// WithRole returns a context that directs MultiUserPool's Exec, Query, QueryRow
+// and Begin to the sub-pool for role.
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleContextKey{}, role)
+}
+
+// RoleFromContext returns the role set with WithRole, if any.
+func RoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(roleContextKey{}).(string)
+	return role, ok
+}
+
+// errRow is a pgx.Row that returns err from Scan.
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...any) error { return r.err }
+
+// connLimiter caps the number of connections across the sub-pools of a
+// MultiUserPool. A connection takes a slot once it is established, before it is
+// added to its pool, and gives it back when it is closed, whether by its pool,
+// by eviction or after being hijacked. Cancel requests and the redials of an
+// authentication retry do not take slots.
+type connLimiter struct {
+	max   int32
+	slots chan struct{}
+	evict func(context.Context)
+}
+
+func newConnLimiter(max int32, evict func(context.Context)) *connLimiter {
+	return &connLimiter{
+		max:   max,
+		slots: make(chan struct{}, max),
+		evict: evict,
+	}
+}
+
+// acquire takes a slot, evicting idle connections or waiting until one is free.
+func (l *connLimiter) acquire(ctx context.Context) error {
+	for {
+		select {
+		case l.slots <- struct{}{}:
+			return nil
+		default:
+		}
+
+		// An evicted connection gives back its slot once its cleanup is done,
+		// so wait for it rather than evicting another.
+		l.evict(ctx)
+
+		select {
+		case l.slots <- struct{}{}:
+			return nil
+		case <-time.After(evictRetryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *connLimiter) release() {
+	<-l.slots
+}
+
+// holdUntil gives back a slot taken with acquire once done is closed.
+func (l *connLimiter) holdUntil(done <-chan struct{}) {
+	go func() {
+		<-done
+		l.release()
+	}()
+}
+
+// afterConnect wraps next, a pool's AfterConnect hook, so that every
+// connection holds a slot while open. The slot is taken before next runs; if
+// either fails, pgxpool closes the connection, which gives the slot back.
+func (l *connLimiter) afterConnect(next func(context.Context, *pgx.Conn) error) func(context.Context, *pgx.Conn) error {
+	return func(ctx context.Context, conn *pgx.Conn) error {
+		if err := l.acquire(ctx); err != nil {
+			return err
+		}
+		l.holdUntil(conn.PgConn().CleanupDone())
+		if next != nil {
+			return next(ctx, conn)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiUserPoolSubPools(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+
+	poolConfig, err := pgxpool.ParseConfig("")
+	require.NoError(t, err)
+	poolConfig.MaxConns = 7
+
+	pool, err := NewMultiUserPool(ctx, Config{
+		Host:                      testHost,
+		CustomCredentialsProvider: countingCredentials(&calls),
+	}, poolConfig)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	tenant, err := pool.Pool("tenant_a")
+	require.NoError(t, err)
+	again, err := pool.Pool("tenant_a")
+	require.NoError(t, err)
+	assert.Same(t, tenant, again)
+
+	admin, err := pool.Pool("admin")
+	require.NoError(t, err)
+	assert.NotSame(t, tenant, admin)
+
+	tests := []struct {
+		pool   *pgxpool.Pool
+		user   string
+		action string
+	}{
+		{tenant, "tenant_a", actionDbConnect},
+		{admin, "admin", actionDbConnectAdmin},
+	}
+	for _, tt := range tests {
+		cfg := tt.pool.Config()
+		assert.Equal(t, int32(7), cfg.MaxConns)
+		assert.Equal(t, int32(0), cfg.MinConns)
+		assert.Equal(t, tt.user, cfg.ConnConfig.User)
+
+		connCfg := cfg.ConnConfig.Copy()
+		require.NoError(t, cfg.BeforeConnect(ctx, connCfg))
+		info, err := ParseToken(connCfg.Password)
+		require.NoError(t, err)
+		assert.Equal(t, tt.action, info.Action)
+	}
+
+	// The token cache is shared: a second connection for a role reuses its token.
+	cfg := tenant.Config()
+	require.NoError(t, cfg.BeforeConnect(ctx, cfg.ConnConfig.Copy()))
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Len(t, pool.pools, 2)
+}
+
+func TestMultiUserPoolConfigErrors(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Host: testHost, TokenProvider: &staticTokenProvider{token: "t"}}
+
+	poolConfig, err := pgxpool.ParseConfig("")
+	require.NoError(t, err)
+	poolConfig.MinConns = 2
+	_, err = NewMultiUserPool(ctx, cfg, poolConfig)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "MinConns")
+
+	_, err = NewMultiUserPool(ctx, "postgres://"+testHost+"/postgres?pool_min_idle_conns=1")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	admin := cfg
+	admin.TokenAction = TokenActionAdmin
+	_, err = NewMultiUserPool(ctx, admin)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), `token action "admin"`)
+}
+
+func TestMultiUserPoolRoles(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewMultiUserPool(ctx, Config{Host: testHost, User: "reader", TokenProvider: &staticTokenProvider{token: "t"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "reader", pool.roleFromContext(ctx))
+	assert.Equal(t, "writer", pool.roleFromContext(WithRole(ctx, "writer")))
+
+	role, ok := RoleFromContext(WithRole(ctx, "writer"))
+	assert.True(t, ok)
+	assert.Equal(t, "writer", role)
+	_, ok = RoleFromContext(ctx)
+	assert.False(t, ok)
+
+	_, err = pool.Acquire(ctx, " ")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	pool.Close()
+	_, err = pool.Acquire(ctx, "reader")
+	assert.ErrorIs(t, err, ErrPoolClosed)
+	assert.ErrorIs(t, pool.QueryRow(ctx, "SELECT 1").Scan(), ErrPoolClosed)
+}
+
+func TestConnLimiterCap(t *testing.T) {
+	limiter := newConnLimiter(1, func(context.Context) {})
+
+	require.NoError(t, limiter.acquire(context.Background()))
+	first := make(chan struct{})
+	limiter.holdUntil(first)
+	assert.Len(t, limiter.slots, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*evictRetryInterval)
+	defer cancel()
+	assert.ErrorIs(t, limiter.acquire(ctx), context.DeadlineExceeded)
+
+	close(first)
+	assert.Eventually(t, func() bool { return len(limiter.slots) == 0 }, time.Second, time.Millisecond)
+
+	require.NoError(t, limiter.acquire(context.Background()))
+	assert.Len(t, limiter.slots, 1)
+}
+
+func TestConnLimiterEvictsIdle(t *testing.T) {
+	idle := make(chan struct{})
+	var evictions atomic.Int32
+	limiter := newConnLimiter(1, func(context.Context) {
+		if evictions.Add(1) == 1 {
+			close(idle)
+		}
+	})
+
+	require.NoError(t, limiter.acquire(context.Background()))
+	limiter.holdUntil(idle)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, limiter.acquire(ctx))
+	assert.Equal(t, int32(1), evictions.Load())
+}
+
+func TestMultiUserPoolCapCountsConnections(t *testing.T) {
+	ctx := context.Background()
+	var dials atomic.Int32
+	provider := &sequenceTokenProvider{}
+	cfg := newPasswordAuthConfig(t, &dials, provider, func(password string) bool {
+		return password != "token-1"
+	})
+
+	poolConfig, err := pgxpool.ParseConfig("")
+	require.NoError(t, err)
+	poolConfig.MaxConns = 1
+
+	pool, err := NewMultiUserPool(ctx, cfg, poolConfig)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// The redial after the first token is rejected does not take a second slot.
+	conn, err := pool.Acquire(ctx, "reader")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), dials.Load())
+	assert.Equal(t, int32(1), pool.TotalConns())
+	conn.Release()
+
+	// A connection for another role evicts the idle one.
+	acquireCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conn, err = pool.Acquire(acquireCtx, "writer")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), pool.TotalConns())
+
+	// A hijacked connection gives back its slot when closed.
+	require.NoError(t, conn.Hijack().Close(ctx))
+	assert.Eventually(t, func() bool { return pool.TotalConns() == 0 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// dsqlParams are the connection string parameters specific to this connector.
+var dsqlParams = map[string]bool{
+	"region":                  true,
+	"profile":                 true,
+	"tokenDurationSecs":       true,
+	"tokenRefreshFraction":    true,
+	"maxAuthRetries":          true,
+	"disableAuthRetry":        true,
+	"credentialSource":        true,
+	"webIdentityTokenFile":    true,
+	"webIdentityRoleArn":      true,
+	"assumeRoleArn":           true,
+	"assumeRoleExternalId":    true,
+	"assumeRoleSessionName":   true,
+	"assumeRoleSessionTags":   true,
+	"assumeRoleDurationSecs":  true,
+	"assumeRoleSourceProfile": true,
+	"tokenAction":             true,
+	"clockSkewCompensation":   true,
+	"serviceName":             true,
+	"dialHost":                true,
+	"endpointVariant":         true,
+}
+
+// pgxParams are standard parameters applied by pgx when parsing a connection config.
+var pgxParams = map[string]bool{
+	"connect_timeout":            true,
+	"statement_cache_capacity":   true,
+	"description_cache_capacity": true,
+	"default_query_exec_mode":    true,
+}
+
+// poolParams are standard parameters applied by pgxpool when parsing a pool config.
+var poolParams = map[string]bool{
+	"pool_max_conns":                true,
+	"pool_min_conns":                true,
+	"pool_min_idle_conns":           true,
+	"pool_max_conn_lifetime":        true,
+	"pool_max_conn_lifetime_jitter": true,
+	"pool_max_conn_idle_time":       true,
+	"pool_health_check_period":      true,
+}
+
+// sessionParams are standard parameters sent to the server as session settings.
+var sessionParams = map[string]bool{
+	"application_name":                    true,
+	"search_path":                         true,
+	"statement_timeout":                   true,
+	"lock_timeout":                        true,
+	"idle_in_transaction_session_timeout": true,
+	"timezone":                            true,
+	"datestyle":                           true,
+	"intervalstyle":                       true,
+	"client_encoding":                     true,
+	"options":                             true,
+}
+
+// TLS is always used with server certificate verification, so sslmode only
+// accepts the modes that this satisfies. See TLSConfig.Mode.
+var sslModes = map[string]bool{
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// validateParams checks that every key in params is a supported standard
+// connection parameter and that pgx accepts the values.
+func validateParams(params map[string]string) error {
+	for key, value := range params {
+		switch {
+		case key == "password":
+			return configErrorf("connection parameter %q is not supported: DSQL authenticates with IAM tokens", key)
+		case key == "sslmode":
+			if !sslModes[value] {
+				return configErrorf("sslmode %q is not supported: DSQL requires TLS", value)
+			}
+		case key == "sslrootcert":
+		case !pgxParams[key] && !poolParams[key] && !sessionParams[key]:
+			return configErrorf("unknown connection parameter %q", key)
+		}
+	}
+
+	if len(params) == 0 {
+		return nil
+	}
+	if _, err := pgxpool.ParseConfig(paramsDSN(params, true)); err != nil {
+		return configErrorf("invalid connection parameters: %w", err)
+	}
+	return nil
+}
+
+// checkRepeatedParams returns an error if a parameter other than the
+// assume-role parameters, which describe one hop each, is given more than once.
+// libpq would keep the last value, which silently hides a mistake.
+func checkRepeatedParams(query url.Values) error {
+	keys := make([]string, 0, len(query))
+	for key, values := range query {
+		if len(values) > 1 && !strings.HasPrefix(key, "assumeRole") {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	return configErrorf("connection parameter %q is given %d times", keys[0], len(query[keys[0]]))
+}
+
+// paramsDSN renders the params applied by pgx, and by pgxpool if withPool is
+// set, as a keyword/value connection string for pgx.ParseConfig or
+// pgxpool.ParseConfig. Session parameters are applied by configureConnConfig.
+func paramsDSN(params map[string]string, withPool bool) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if pgxParams[key] || (withPool && poolParams[key]) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + "='" + quote.Replace(params[key]) + "'"
+	}
+	return strings.Join(parts, " ")
+}
+
+// clearEnvSettings resets the settings of connConfig that pgx.ParseConfig and
+// pgxpool.ParseConfig read from PG* environment variables, such as PGAPPNAME,
+// PGOPTIONS and PGTZ, and that are neither in params nor replaced by
+// configureConnConfig, so that the Config alone determines the connection.
+func clearEnvSettings(connConfig *pgx.ConnConfig, params map[string]string) {
+	if _, ok := params["connect_timeout"]; !ok {
+		connConfig.ConnectTimeout = 0
+	}
+	connConfig.RuntimeParams = make(map[string]string)
+	connConfig.SSLNegotiation = ""
+	connConfig.ValidateConnect = nil
+	connConfig.KerberosSrvName = ""
+	connConfig.KerberosSpn = ""
+}
+
+// hasConnParams reports whether params contains parameters that are applied
+// when parsing the connection or pool config, rather than session parameters.
+func hasConnParams(params map[string]string) bool {
+	for key := range params {
+		if pgxParams[key] || poolParams[key] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConnectionStringParams(t *testing.T) {
+	expected := map[string]string{
+		"connect_timeout":         "5",
+		"application_name":        "orders",
+		"search_path":             "app,public",
+		"default_query_exec_mode": "simple_protocol",
+		"pool_max_conns":          "7",
+		"pool_max_conn_lifetime":  "20m",
+		"sslmode":                 "verify-full",
+	}
+
+	cfg, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres?region=us-east-1" +
+		"&connect_timeout=5&application_name=orders&search_path=app,public&default_query_exec_mode=simple_protocol" +
+		"&pool_max_conns=7&pool_max_conn_lifetime=20m&sslmode=verify-full")
+	require.NoError(t, err)
+	assert.Equal(t, "us-east-1", cfg.Region)
+	assert.Equal(t, expected, cfg.Params)
+
+	cfg, err = ParseConnectionString("host=mycluster.dsql.us-east-1.on.aws connect_timeout=5 application_name=orders " +
+		"search_path='app,public' default_query_exec_mode=simple_protocol pool_max_conns=7 pool_max_conn_lifetime=20m sslmode=verify-full")
+	require.NoError(t, err)
+	assert.Equal(t, expected, cfg.Params)
+}
+
+func TestParseConnectionStringParamErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		errMsg string
+	}{
+		{"unknown parameter", "regoin=us-east-1", `unknown connection parameter "regoin"`},
+		{"password", "password=secret", `connection parameter "password" is not supported`},
+		{"sslmode disable", "sslmode=disable", `sslmode "disable" is not supported`},
+		{"invalid value", "pool_max_conns=many", "invalid connection parameters"},
+		{"invalid timeout", "connect_timeout=soon", "invalid connection parameters"},
+		{"repeated parameter", "search_path=a&search_path=b", `connection parameter "search_path" is given 2 times`},
+		{"repeated DSQL parameter", "region=us-east-1&region=us-west-2", `connection parameter "region" is given 2 times`},
+		{"repeated host", "host=a&host=b", `connection parameter "host" is given 2 times`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres?" + tt.query)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	_, err := ParseConnectionString("host=mycluster.dsql.us-east-1.on.aws user=app user=admin")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), `connection parameter "user" is given 2 times`)
+
+	_, err = (&Config{Host: "mycluster.dsql.us-east-1.on.aws", Params: map[string]string{"statement_timeout_ms": "5"}}).resolve()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown connection parameter "statement_timeout_ms"`)
+}
+
+func TestNewPoolAppliesParams(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres" +
+		"?connect_timeout=5&search_path=app&default_query_exec_mode=exec&pool_max_conns=7&pool_max_conn_lifetime=20m")
+	require.NoError(t, err)
+	cfg.TokenProvider = &staticTokenProvider{token: "t"}
+
+	pool, err := NewPool(ctx, cfg)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	poolCfg := pool.Config()
+	assert.Equal(t, int32(7), poolCfg.MaxConns)
+	assert.Equal(t, 20*time.Minute, poolCfg.MaxConnLifetime)
+	assert.Equal(t, DefaultMaxConnIdleTime, poolCfg.MaxConnIdleTime)
+	assert.Equal(t, 5*time.Second, poolCfg.ConnConfig.ConnectTimeout)
+	assert.Equal(t, pgx.QueryExecModeExec, poolCfg.ConnConfig.DefaultQueryExecMode)
+	assert.Equal(t, map[string]string{"application_name": ApplicationName, "search_path": "app"}, poolCfg.ConnConfig.RuntimeParams)
+
+	// Connection and pool parameters cannot be merged into a provided pool config.
+	provided, err := pgxpool.ParseConfig("")
+	require.NoError(t, err)
+	_, err = NewPool(ctx, cfg, provided)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestConfigureConnConfigApplicationName(t *testing.T) {
+	resolved, err := (&Config{Host: "mycluster.dsql.us-east-1.on.aws", Params: map[string]string{"application_name": "orders"}}).resolve()
+	require.NoError(t, err)
+
+	connConfig, err := pgx.ParseConfig(paramsDSN(resolved.Params, false))
+	require.NoError(t, err)
+	resolved.configureConnConfig(connConfig)
+	assert.Equal(t, "orders", connConfig.RuntimeParams["application_name"])
+}
+
+func TestConfigureConnConfigRuntimeParams(t *testing.T) {
+	ctx := context.Background()
+
+	provided, err := pgxpool.ParseConfig("search_path=app statement_timeout=5s lock_timeout=1s app.tenant=acme")
+	require.NoError(t, err)
+
+	cfg := Config{
+		Host:          "mycluster.dsql.us-east-1.on.aws",
+		TokenProvider: &staticTokenProvider{token: "t"},
+		Params:        map[string]string{"statement_timeout": "10s"},
+		RuntimeParams: map[string]string{"lock_timeout": "2s", "app.region": "east"},
+		ServiceName:   "orders-svc",
+	}
+	pool, err := NewPool(ctx, cfg, provided)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	assert.Equal(t, map[string]string{
+		"application_name":  "aurora-dsql-go-pgx/" + Version + " orders-svc",
+		"search_path":       "app",
+		"statement_timeout": "10s",
+		"lock_timeout":      "2s",
+		"app.tenant":        "acme",
+		"app.region":        "east",
+	}, pool.Config().ConnConfig.RuntimeParams)
+
+	// An explicit application_name replaces the default.
+	cfg.RuntimeParams = map[string]string{"application_name": "custom"}
+	resolved, err := cfg.resolve()
+	require.NoError(t, err)
+	connConfig, err := pgx.ParseConfig("")
+	require.NoError(t, err)
+	resolved.configureConnConfig(connConfig)
+	assert.Equal(t, "custom", connConfig.RuntimeParams["application_name"])
+
+	parsed, err := ParseConnectionString("postgres://admin@mycluster.dsql.us-east-1.on.aws/postgres?serviceName=orders-svc")
+	require.NoError(t, err)
+	assert.Equal(t, "orders-svc", parsed.ServiceName)
+
+	err = Config{Host: cfg.Host, ServiceName: " orders", RuntimeParams: map[string]string{"": "x"}}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "RuntimeParams: runtime parameter names must not be blank")
+	assert.Contains(t, err.Error(), "ServiceName: service name must not have leading or trailing spaces")
+}
+
+func TestParamsDSNQuoting(t *testing.T) {
+	dsn := paramsDSN(map[string]string{
+		"connect_timeout":  "5",
+		"pool_max_conns":   "3",
+		"search_path":      "ignored",
+		"application_name": "ignored",
+	}, true)
+	assert.Equal(t, "connect_timeout='5' pool_max_conns='3'", dsn)
+	assert.Equal(t, "connect_timeout='5'", paramsDSN(map[string]string{"connect_timeout": "5", "pool_max_conns": "3"}, false))
+
+	params, err := splitKeywordValues(paramsDSN(map[string]string{"default_query_exec_mode": `it's \ odd`}, false))
+	require.NoError(t, err)
+	assert.Equal(t, `it's \ odd`, params.Get("default_query_exec_mode"))
+}
+
+func TestConnConfigIgnoresEnv(t *testing.T) {
+	t.Setenv("PGAPPNAME", "from-env")
+	t.Setenv("PGOPTIONS", "-c search_path=env")
+	t.Setenv("PGTZ", "Asia/Tokyo")
+	t.Setenv("PGCONNECT_TIMEOUT", "9")
+
+	resolved, err := (&Config{Host: "mycluster.dsql.us-east-1.on.aws", Params: map[string]string{"search_path": "app"}}).resolve()
+	require.NoError(t, err)
+	require.NoError(t, resolved.loadTLSConfig())
+	expected := map[string]string{"application_name": ApplicationName, "search_path": "app"}
+
+	connConfig, err := resolved.newConnConfig()
+	require.NoError(t, err)
+	assert.Equal(t, expected, connConfig.RuntimeParams)
+	assert.Zero(t, connConfig.ConnectTimeout)
+
+	pool, err := NewPool(context.Background(), Config{
+		Host:          "mycluster.dsql.us-east-1.on.aws",
+		Params:        map[string]string{"search_path": "app"},
+		TokenProvider: &staticTokenProvider{token: "t"},
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+	assert.Equal(t, expected, pool.Config().ConnConfig.RuntimeParams)
+	assert.Zero(t, pool.Config().ConnConfig.ConnectTimeout)
+}
@@ -23,24 +23,9 @@ import (
 // poolConfig will be chained with the connector's IAM token generation (user callback
 // runs first).
 func NewPool(ctx context.Context, config any, poolConfig ...*pgxpool.Config) (*pgxpool.Pool, error) {
-	var cfg *Config
-
-	switch c := config.(type) {
-	case Config:
-		cfg = &c
-	case *Config:
-		if c == nil {
-			return nil, fmt.Errorf("config cannot be nil")
-		}
-		cfg = c
-	case string:
-		parsed, err := ParseConnectionString(c)
-		if err != nil {
-			return nil, err
-		}
-		cfg = parsed
-	default:
-		return nil, fmt.Errorf("config must be Config, *Config, or string, got %T", config)
+	cfg, err := toConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
 	resolved, err := cfg.resolve()
@@ -57,30 +42,63 @@ func NewPool(ctx context.Context, config any, poolConfig ...*pgxpool.Config) (*p
 }
 
 func newPoolFromResolved(ctx context.Context, resolved *resolvedConfig, poolConfig *pgxpool.Config) (*pgxpool.Pool, error) {
-	credentialsProvider, err := resolveCredentialsProvider(ctx, resolved)
+	if err := resolved.loadTLSConfig(); err != nil {
+		return nil, err
+	}
+
+	var clock *skewClock
+	if resolved.compensatesSkew() {
+		clock = newSkewClock()
+	}
+
+	tokenProvider, err := poolTokenProvider(ctx, resolved, clock)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve credentials provider: %w", err)
+		return nil, err
+	}
+
+	poolConfig, err = resolved.configurePoolConfig(poolConfig, tokenProvider, clock)
+	if err != nil {
+		return nil, err
 	}
 
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create connection pool: %w", err)
+	}
+
+	return pool, nil
+}
+
+// configurePoolConfig applies the DSQL connection settings, defaults and hooks
+// to poolConfig, or to a new pool config if poolConfig is nil, so that new
+// connections authenticate with tokens from tokenProvider.
+func (r *resolvedConfig) configurePoolConfig(poolConfig *pgxpool.Config, tokenProvider TokenProvider, clock *skewClock) (*pgxpool.Config, error) {
+	var err error
 	applyDSQLDefaults := poolConfig == nil
 	if poolConfig == nil {
-		poolConfig, err = pgxpool.ParseConfig("")
+		poolConfig, err = pgxpool.ParseConfig(paramsDSN(r.Params, true))
 		if err != nil {
 			return nil, fmt.Errorf("unable to create pool config: %w", err)
 		}
+		clearEnvSettings(poolConfig.ConnConfig, r.Params)
+	} else if hasConnParams(r.Params) {
+		return nil, configErrorf("connection parameters cannot be combined with a provided pool config; set them on the pool config instead")
 	}
 
-	resolved.configureConnConfig(poolConfig.ConnConfig)
+	r.configureConnConfig(poolConfig.ConnConfig)
 
 	// Apply DSQL-optimized defaults. When no pool config was provided,
 	// always override pgxpool defaults. When the user provides their own
 	// config, only fill in zero values so that users who don't explicitly
 	// set lifetimes still get safe connection recycling on DSQL (where
 	// connections timeout server-side after 60 minutes).
-	if applyDSQLDefaults || poolConfig.MaxConnLifetime == 0 {
+	// Lifetimes set through connection parameters are kept.
+	_, lifetimeSet := r.Params["pool_max_conn_lifetime"]
+	_, idleTimeSet := r.Params["pool_max_conn_idle_time"]
+	if (applyDSQLDefaults && !lifetimeSet) || poolConfig.MaxConnLifetime == 0 {
 		poolConfig.MaxConnLifetime = DefaultMaxConnLifetime
 	}
-	if applyDSQLDefaults || poolConfig.MaxConnIdleTime == 0 {
+	if (applyDSQLDefaults && !idleTimeSet) || poolConfig.MaxConnIdleTime == 0 {
 		poolConfig.MaxConnIdleTime = DefaultMaxConnIdleTime
 	}
 
@@ -92,7 +110,7 @@ func newPoolFromResolved(ctx context.Context, resolved *resolvedConfig, poolConf
 				return err
 			}
 		}
-		token, err := GenerateToken(ctx, resolved.Host, resolved.Region, resolved.User, credentialsProvider, resolved.TokenDuration)
+		token, _, err := tokenProvider.Token(ctx, r.Host, r.Region, r.User)
 		if err != nil {
 			return err
 		}
@@ -100,10 +118,62 @@ func newPoolFromResolved(ctx context.Context, resolved *resolvedConfig, poolConf
 		return nil
 	}
 
-	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	// Measure clock skew against the server once connections succeed.
+	if clock != nil {
+		userAfterConnect := poolConfig.AfterConnect
+		poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			if userAfterConnect != nil {
+				if err := userAfterConnect(ctx, conn); err != nil {
+					return err
+				}
+			}
+			r.measureServerSkew(ctx, clock, conn)
+			return nil
+		}
+	}
+
+	// pgxpool returns connection errors to the caller without a hook, so watch
+	// the startup handshake instead: after an authentication rejection, cached
+	// credentials and tokens are discarded and the attempt is repeated with a
+	// fresh token, up to r.AuthRetries times. A final rejection is reported as
+	// an *AuthError, as Connect does. With a pgx version the retry was not
+	// verified against, rejections are only observed, and the next attempt
+	// uses a fresh token.
+	var onAuthFailure func()
+	if r.AuthRetries > 0 {
+		onAuthFailure = func() { invalidateTokenProvider(tokenProvider) }
+	}
+	retries := r.AuthRetries
+	authError := func(err error) error { return newAuthError(r, clock, err) }
+	if !inConnectionRetrySupported() {
+		retries, authError = 0, nil
+	}
+	poolConfig.ConnConfig.AfterNetConnect = newAuthFailureDetector(
+		poolConfig.ConnConfig.AfterNetConnect,
+		onAuthFailure,
+		retries,
+		func(ctx context.Context) (string, error) {
+			token, _, err := tokenProvider.Token(ctx, r.Host, r.Region, r.User)
+			return token, err
+		},
+		authError,
+	)
+
+	return poolConfig, nil
+}
+
+// poolTokenProvider returns the token provider used by a pool's BeforeConnect hook.
+// Unless a custom TokenProvider is configured, tokens are cached per pool so that
+// bursts of new connections share a single presign and credentials retrieval.
+func poolTokenProvider(ctx context.Context, resolved *resolvedConfig, clock *skewClock) (TokenProvider, error) {
+	if resolved.TokenProvider != nil {
+		return resolved.TokenProvider, nil
+	}
+
+	credentialsProvider, err := resolveCredentialsProvider(ctx, resolved)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create connection pool: %w", err)
+		return nil, fmt.Errorf("failed to resolve credentials provider: %w", err)
 	}
 
-	return pool, nil
+	return NewTokenCache(resolved.newSigV4TokenProvider(credentialsProvider, clock), resolved.TokenRefreshFraction), nil
 }
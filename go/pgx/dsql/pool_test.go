@@ -24,6 +24,25 @@ func TestNewPoolNilConfig(t *testing.T) {
 	assert.Contains(t, err.Error(), "config cannot be nil")
 }
 
+func TestNewPoolCustomTokenProvider(t *testing.T) {
+	ctx := context.Background()
+	provider := &staticTokenProvider{token: "sidecar-token"}
+
+	pool, err := NewPool(ctx, Config{
+		Host:          "mycluster.dsql.us-east-1.on.aws",
+		TokenProvider: provider,
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	poolCfg := pool.Config()
+	connCfg := poolCfg.ConnConfig.Copy()
+	require.NoError(t, poolCfg.BeforeConnect(ctx, connCfg))
+
+	assert.Equal(t, "sidecar-token", connCfg.Password)
+	assert.Equal(t, int32(1), provider.calls.Load())
+}
+
 func TestNewPool(t *testing.T) {
 	endpoint := os.Getenv("CLUSTER_ENDPOINT")
 	region := os.Getenv("REGION")
@@ -0,0 +1,336 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"cmp"
+	"context"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// DefaultRouterProbeInterval is how often a RegionalRouter measures the
+	// round-trip time to each region.
+	DefaultRouterProbeInterval = 10 * time.Second
+
+	// rttSmoothing is the weight of each new sample in the smoothed RTT.
+	rttSmoothing = 0.3
+)
+
+// RegionalRouter routes connections across the regional endpoints of a
+// multi-region cluster, preferring the closest.
+//
+// The router measures the round-trip time of SELECT 1 on a pooled connection
+// to each region every DefaultRouterProbeInterval, and smooths the samples.
+// Each acquisition picks a healthy region at random, weighted by the inverse
+// square of its RTT, so that a region twice as far away receives a quarter as
+// many connections. If connecting fails, the other healthy regions are tried,
+// fastest first. Regions are marked unhealthy and recover as in FailoverPool.
+type RegionalRouter struct {
+	regions   []*routedRegion
+	threshold int
+	random    func() float64
+
+	stop chan struct{}
+	done chan struct{}
+
+	closed    atomic.Bool
+	closeOnce sync.Once
+}
+
+// routedRegion is a region of a RegionalRouter with its latency measurements.
+type routedRegion struct {
+	*failoverRegion
+
+	rtt      atomic.Int64 // smoothed, in nanoseconds; 0 until measured
+	lastRTT  atomic.Int64
+	acquired atomic.Int64
+}
+
+// RegionStats reports the health, latency and routing share of one region of
+// a RegionalRouter.
+type RegionStats struct {
+	RegionStatus
+
+	// RTT is the smoothed round-trip time of SELECT 1, or 0 if the region has
+	// not been measured.
+	RTT time.Duration
+
+	// LastRTT is the most recent round-trip time measured.
+	LastRTT time.Duration
+
+	// Weight is the share of new connections currently routed to the region,
+	// between 0 and 1.
+	Weight float64
+
+	// Acquired is the number of connections acquired from the region.
+	Acquired int64
+}
+
+// NewRegionalRouter creates a RegionalRouter for the regional endpoints in
+// regions. Each Config is resolved and validated as for NewPool, and every
+// region must have a distinct endpoint.
+//
+// The first measurement starts immediately. Until it completes, healthy regions
+// are weighted equally.
+func NewRegionalRouter(ctx context.Context, regions ...Config) (*RegionalRouter, error) {
+	return newRegionalRouter(ctx, DefaultRouterProbeInterval, regions)
+}
+
+func newRegionalRouter(ctx context.Context, probeInterval time.Duration, configs []Config) (*RegionalRouter, error) {
+	if len(configs) == 0 {
+		return nil, configErrorf("at least one region is required")
+	}
+	regions, err := newRegionPools(ctx, configs)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &RegionalRouter{
+		threshold: DefaultFailoverThreshold,
+		random:    rand.Float64,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, r := range regions {
+		rt.regions = append(rt.regions, &routedRegion{failoverRegion: r})
+	}
+	go rt.probeLoop(probeInterval)
+	return rt, nil
+}
+
+// Acquire returns a connection from a region chosen by latency, failing over to
+// the other regions if connecting fails. If every region fails, the errors of
+// all regions are returned.
+func (rt *RegionalRouter) Acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	if rt.closed.Load() {
+		return nil, ErrPoolClosed
+	}
+
+	order := rt.candidates()
+	candidates := make([]*failoverRegion, len(order))
+	for i, r := range order {
+		candidates[i] = r.failoverRegion
+	}
+	conn, chosen, err := acquireFrom(ctx, candidates, rt.threshold)
+	if err != nil {
+		return nil, err
+	}
+	order[slices.Index(candidates, chosen)].acquired.Add(1)
+	return conn, nil
+}
+
+// candidates returns the regions to try: one healthy region picked at random by
+// weight, the other healthy regions fastest first, then unhealthy regions.
+func (rt *RegionalRouter) candidates() []*routedRegion {
+	weights := rt.weights()
+	order := make([]int, len(rt.regions))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(weights[b], weights[a])
+	})
+
+	// Move the randomly picked region to the front.
+	pick := rt.random()
+	for i, index := range order {
+		if weights[index] == 0 {
+			break
+		}
+		if pick < weights[index] {
+			copy(order[1:i+1], order[:i])
+			order[0] = index
+			break
+		}
+		pick -= weights[index]
+	}
+
+	candidates := make([]*routedRegion, len(order))
+	for i, index := range order {
+		candidates[i] = rt.regions[index]
+	}
+	return candidates
+}
+
+// weights returns the share of new connections for each region: in proportion
+// to 1/RTT² among healthy regions, and 0 for unhealthy regions. Healthy regions
+// not yet measured get the mean weight of the measured ones, or an equal share
+// if none is measured.
+func (rt *RegionalRouter) weights() []float64 {
+	weights := make([]float64, len(rt.regions))
+	var unmeasured []int
+	var measuredSum float64
+	measured := 0
+	for i, r := range rt.regions {
+		if !r.isHealthy() {
+			continue
+		}
+		if rtt := float64(r.rtt.Load()); rtt > 0 {
+			weights[i] = 1 / (rtt * rtt)
+			measuredSum += weights[i]
+			measured++
+		} else {
+			unmeasured = append(unmeasured, i)
+		}
+	}
+
+	fill := 1.0
+	if measured > 0 {
+		fill = measuredSum / float64(measured)
+	}
+	for _, i := range unmeasured {
+		weights[i] = fill
+	}
+
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	if sum > 0 {
+		for i := range weights {
+			weights[i] /= sum
+		}
+	}
+	return weights
+}
+
+// Stats returns the health, latency and routing share of each region, in the
+// order the regions were given.
+func (rt *RegionalRouter) Stats() []RegionStats {
+	weights := rt.weights()
+	stats := make([]RegionStats, len(rt.regions))
+	for i, r := range rt.regions {
+		stats[i] = RegionStats{
+			RegionStatus: r.status(),
+			RTT:          time.Duration(r.rtt.Load()),
+			LastRTT:      time.Duration(r.lastRTT.Load()),
+			Weight:       weights[i],
+			Acquired:     r.acquired.Load(),
+		}
+	}
+	return stats
+}
+
+// Close stops measurements and closes the pool of every region.
+func (rt *RegionalRouter) Close() {
+	rt.closeOnce.Do(func() {
+		rt.closed.Store(true)
+		close(rt.stop)
+		<-rt.done
+		for _, r := range rt.regions {
+			r.pool.Close()
+		}
+	})
+}
+
+// Exec acquires a connection from a region chosen by latency and executes sql.
+func (rt *RegionalRouter) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	return execAcquired(ctx, rt.Acquire, sql, arguments...)
+}
+
+// Query acquires a connection from a region chosen by latency and executes a
+// query. The connection is released when the rows are closed.
+func (rt *RegionalRouter) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return queryAcquired(ctx, rt.Acquire, sql, args...)
+}
+
+// QueryRow acquires a connection from a region chosen by latency and executes a
+// query that is expected to return at most one row.
+func (rt *RegionalRouter) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return queryRowAcquired(ctx, rt.Acquire, sql, args...)
+}
+
+// Begin acquires a connection from a region chosen by latency and starts a
+// transaction. The connection is released when the transaction is committed or
+// rolled back.
+func (rt *RegionalRouter) Begin(ctx context.Context) (pgx.Tx, error) {
+	return beginAcquired(ctx, rt.Acquire)
+}
+
+// probeLoop measures every region immediately and then each interval until the
+// router is closed.
+func (rt *RegionalRouter) probeLoop(interval time.Duration) {
+	defer close(rt.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		rt.probe(ctx)
+		cancel()
+
+		select {
+		case <-rt.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe measures the RTT of every region concurrently, which also serves as a
+// health check.
+func (rt *RegionalRouter) probe(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range rt.regions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtt, err := r.measure(ctx)
+			if err != nil {
+				if isRegionFailure(err) {
+					r.recordFailure(err, rt.threshold)
+				}
+				return
+			}
+			r.recordSuccess()
+			r.recordRTT(rtt)
+		}()
+	}
+	wg.Wait()
+}
+
+// measure returns the round-trip time of SELECT 1 on a connection from r's
+// pool. The time to open a connection, if none is idle, is not included.
+func (r *routedRegion) measure(ctx context.Context) (time.Duration, error) {
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	start := time.Now()
+	if _, err := conn.Exec(ctx, "SELECT 1"); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// recordRTT adds a sample to the smoothed RTT.
+func (r *routedRegion) recordRTT(rtt time.Duration) {
+	if rtt <= 0 {
+		rtt = 1
+	}
+	r.lastRTT.Store(int64(rtt))
+	for {
+		old := r.rtt.Load()
+		smoothed := int64(rtt)
+		if old > 0 {
+			smoothed = int64(rttSmoothing*float64(rtt) + (1-rttSmoothing)*float64(old))
+		}
+		if r.rtt.CompareAndSwap(old, smoothed) {
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegionalRouter(t *testing.T) {
+	const (
+		eastHost = "ijsamhssbh36dopuigphknejb4.dsql.us-east-1.on.aws"
+		westHost = "ijsamhssbh36dopuigphknejb4.dsql.us-west-2.on.aws"
+	)
+	east, eastConfig := newFakeRegion(t, eastHost)
+	west, westConfig := newFakeRegion(t, westHost)
+	west.delay.Store(int64(50 * time.Millisecond))
+
+	router, err := newRegionalRouter(context.Background(), time.Hour, []Config{eastConfig, westConfig})
+	require.NoError(t, err)
+	defer router.Close()
+
+	// Wait for the first measurement, then measure again by hand.
+	require.Eventually(t, func() bool {
+		stats := router.Stats()
+		return stats[0].RTT > 0 && stats[1].RTT > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	router.probe(context.Background())
+
+	stats := router.Stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "us-east-1", stats[0].Region)
+	assert.Positive(t, stats[0].RTT)
+	assert.Less(t, stats[0].RTT, stats[1].RTT)
+	assert.GreaterOrEqual(t, stats[1].LastRTT, 50*time.Millisecond)
+	assert.Greater(t, stats[0].Weight, 0.9)
+	assert.InDelta(t, 1, stats[0].Weight+stats[1].Weight, 1e-9)
+
+	// Acquisitions are picked by weight, with the faster region first.
+	router.random = func() float64 { return 0 }
+	assert.Equal(t, eastHost, routedHost(t, router))
+	router.random = func() float64 { return 0.9999999 }
+	assert.Equal(t, westHost, routedHost(t, router))
+
+	// A region that fails its measurements gets no new connections.
+	east.down.Store(true)
+	router.regions[0].pool.Reset()
+	router.probe(context.Background())
+	router.probe(context.Background())
+	stats = router.Stats()
+	assert.False(t, stats[0].Healthy)
+	assert.Zero(t, stats[0].Weight)
+	assert.Equal(t, 1.0, stats[1].Weight)
+
+	router.random = func() float64 { return 0 }
+	assert.Equal(t, westHost, routedHost(t, router))
+
+	tag, err := router.Exec(context.Background(), "select 1")
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 1", tag.String())
+
+	stats = router.Stats()
+	assert.Equal(t, int64(1), stats[0].Acquired)
+	assert.Equal(t, int64(3), stats[1].Acquired)
+
+	// It recovers once a measurement succeeds.
+	east.down.Store(false)
+	router.probe(context.Background())
+	assert.True(t, router.Stats()[0].Healthy)
+	assert.Equal(t, eastHost, routedHost(t, router))
+}
+
+func TestRegionalRouterWeights(t *testing.T) {
+	router := &RegionalRouter{}
+	for _, rtt := range []time.Duration{0, 0, 0} {
+		region := &routedRegion{failoverRegion: &failoverRegion{healthy: true}}
+		region.rtt.Store(int64(rtt))
+		router.regions = append(router.regions, region)
+	}
+
+	// Before the first measurement, healthy regions are weighted equally.
+	assert.Equal(t, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}, router.weights())
+
+	// Weights are in proportion to 1/RTT², and an unmeasured region gets the
+	// mean weight of the measured ones.
+	router.regions[0].rtt.Store(int64(10 * time.Millisecond))
+	router.regions[1].rtt.Store(int64(20 * time.Millisecond))
+	weights := router.weights()
+	assert.InDelta(t, 4*weights[1], weights[0], 1e-9)
+	assert.InDelta(t, (weights[0]+weights[1])/2, weights[2], 1e-9)
+
+	router.regions[0].healthy = false
+	weights = router.weights()
+	assert.Zero(t, weights[0])
+	assert.InDelta(t, 0.5, weights[1], 1e-9)
+}
+
+func TestRegionalRouterFailover(t *testing.T) {
+	east, eastConfig := newFakeRegion(t, "ijsamhssbh36dopuigphknejb4.dsql.us-east-1.on.aws")
+	_, westConfig := newFakeRegion(t, "ijsamhssbh36dopuigphknejb4.dsql.us-west-2.on.aws")
+
+	router, err := newRegionalRouter(context.Background(), time.Hour, []Config{eastConfig, westConfig})
+	require.NoError(t, err)
+	defer router.Close()
+
+	// Connecting to the picked region fails, so the other is used.
+	east.down.Store(true)
+	router.regions[0].pool.Reset()
+	router.random = func() float64 { return 0 }
+	conn, err := router.Acquire(context.Background())
+	require.NoError(t, err)
+	defer conn.Release()
+	assert.Equal(t, "us-west-2", router.regions[1].region)
+	assert.Equal(t, router.regions[1].host, conn.Conn().Config().TLSConfig.ServerName)
+}
+
+func TestNewRegionalRouterErrors(t *testing.T) {
+	_, err := NewRegionalRouter(context.Background())
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+// routedHost returns the cluster host of a connection acquired from router.
+func routedHost(t *testing.T, router *RegionalRouter) string {
+	t.Helper()
+	conn, err := router.Acquire(context.Background())
+	require.NoError(t, err)
+	defer conn.Release()
+	return conn.Conn().Config().TLSConfig.ServerName
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Special values of TLSConfig.RootCertFile and the sslrootcert connection parameter.
+const (
+	// RootCertSystem verifies the server with the system's root certificates.
+	RootCertSystem = "system"
+
+	// RootCertAmazon verifies the server with the bundled Amazon Trust Services
+	// root certificates, as TLSConfig.AmazonRoots does.
+	RootCertAmazon = "amazon"
+)
+
+// amazonRootsPEM holds the Amazon Trust Services root certificates that DSQL
+// server certificates chain to.
+//
+//go:embed certs/amazon-trust-services.pem
+var amazonRootsPEM []byte
+
+// amazonRoots returns a pool of the bundled Amazon Trust Services roots.
+var amazonRoots = sync.OnceValue(func() *x509.CertPool {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(amazonRootsPEM) {
+		panic("dsql: invalid bundled Amazon root certificates")
+	}
+	return pool
+})
+
+// TLSConfig configures TLS for connections to DSQL. Connections always use TLS.
+type TLSConfig struct {
+	// Mode is the sslmode: "verify-full" verifies the server's certificate chain
+	// and hostname, and "verify-ca" only its chain. "require" and "prefer" are
+	// accepted for compatibility and behave as "verify-full". Optional.
+	// Default: the sslmode connection parameter, or "verify-full".
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty" toml:"mode,omitempty"`
+
+	// RootCAs is the pool of root certificates used to verify the server.
+	// Optional. Default: the system roots.
+	RootCAs *x509.CertPool `json:"-" yaml:"-" toml:"-"`
+
+	// RootCertFile is a PEM file of root certificates used to verify the server,
+	// or RootCertSystem or RootCertAmazon. The file is read when a pool or
+	// connection is created, not by Validate or String. Optional. Default: the
+	// sslrootcert connection parameter.
+	RootCertFile string `json:"rootCertFile,omitempty" yaml:"rootCertFile,omitempty" toml:"rootCertFile,omitempty"`
+
+	// AmazonRoots verifies the server with the Amazon Trust Services root
+	// certificates bundled with the connector, for environments without a CA
+	// bundle. Optional.
+	AmazonRoots bool `json:"amazonRoots,omitempty" yaml:"amazonRoots,omitempty" toml:"amazonRoots,omitempty"`
+
+	// PinnedPublicKeys are base64-encoded SHA-256 hashes of the
+	// SubjectPublicKeyInfo of certificates in the server's chain. If set, a
+	// verified chain must contain a certificate with one of these keys. Optional.
+	PinnedPublicKeys []string `json:"pinnedPublicKeys,omitempty" yaml:"pinnedPublicKeys,omitempty" toml:"pinnedPublicKeys,omitempty"`
+
+	// MinVersion is the minimum TLS version, tls.VersionTLS12 or
+	// tls.VersionTLS13. Optional. Default: tls.VersionTLS12.
+	MinVersion uint16 `json:"-" yaml:"-" toml:"-"`
+}
+
+// validate checks the fields of t that can be checked without reading files.
+func (t *TLSConfig) validate() error {
+	var errs []error
+	if t.Mode != "" && !sslModes[t.Mode] {
+		errs = append(errs, configErrorf("sslmode %q is not supported: DSQL requires TLS", t.Mode))
+	}
+
+	sources := 0
+	for _, set := range []bool{t.RootCAs != nil, t.RootCertFile != "", t.AmazonRoots} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		errs = append(errs, configErrorf("only one of RootCAs, RootCertFile and AmazonRoots may be set"))
+	}
+
+	if _, err := decodePins(t.PinnedPublicKeys); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch t.MinVersion {
+	case 0, tls.VersionTLS12, tls.VersionTLS13:
+	default:
+		errs = append(errs, configErrorf("minimum TLS version must be TLS 1.2 or TLS 1.3, got %s", tls.VersionName(t.MinVersion)))
+	}
+	return errors.Join(errs...)
+}
+
+// decodePins decodes base64-encoded SHA-256 public key hashes.
+func decodePins(pins []string) ([][]byte, error) {
+	decoded := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		sum, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, configErrorf("pinned public key %q is not a base64-encoded SHA-256 hash", pin)
+		}
+		decoded = append(decoded, sum)
+	}
+	return decoded, nil
+}
+
+// PublicKeyPin returns the pin of cert's public key for TLSConfig.PinnedPublicKeys.
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// loadTLSConfig sets r.TLSConfig, for connections to r.Host, from r.TLS and the
+// sslmode and sslrootcert connection parameters. It reads the root certificate
+// file, so it is called before connecting rather than by resolve.
+func (r *resolvedConfig) loadTLSConfig() error {
+	if r.TLSConfig != nil {
+		return nil
+	}
+	t := r.TLS
+	if t.Mode == "" {
+		t.Mode = r.Params["sslmode"]
+	}
+	if t.RootCAs == nil && t.RootCertFile == "" && !t.AmazonRoots {
+		t.RootCertFile = r.Params["sslrootcert"]
+	}
+
+	roots := t.RootCAs
+	switch {
+	case roots != nil:
+	case t.AmazonRoots || t.RootCertFile == RootCertAmazon:
+		roots = amazonRoots()
+	case t.RootCertFile != "" && t.RootCertFile != RootCertSystem:
+		pem, err := os.ReadFile(t.RootCertFile)
+		if err != nil {
+			return configErrorf("unable to read root certificates: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(bytes.TrimSpace(pem)) {
+			return configErrorf("no PEM certificates found in %s", t.RootCertFile)
+		}
+	}
+
+	pins, err := decodePins(t.PinnedPublicKeys)
+	if err != nil {
+		return err
+	}
+
+	cfg := &tls.Config{
+		ServerName: r.Host,
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS12,
+	}
+	if t.MinVersion > cfg.MinVersion {
+		cfg.MinVersion = t.MinVersion
+	}
+
+	verifyHostname := t.Mode != "verify-ca"
+	if !verifyHostname {
+		// The chain is verified by verifyConnection instead.
+		cfg.InsecureSkipVerify = true
+	}
+	if !verifyHostname || len(pins) > 0 {
+		cfg.VerifyConnection = verifyConnection(roots, verifyHostname, pins)
+	}
+	r.TLSConfig = cfg
+	return nil
+}
+
+// verifyConnection returns a tls.Config.VerifyConnection callback that verifies
+// the server's certificate chain if the handshake did not, and checks the
+// public key pins.
+func verifyConnection(roots *x509.CertPool, chainVerified bool, pins [][]byte) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		chains := cs.VerifiedChains
+		if !chainVerified {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("tls: server presented no certificates")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			var err error
+			if chains, err = cs.PeerCertificates[0].Verify(opts); err != nil {
+				return err
+			}
+		}
+
+		if len(pins) == 0 {
+			return nil
+		}
+		for _, chain := range chains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if bytes.Equal(sum[:], pin) {
+						return nil
+					}
+				}
+			}
+		}
+		return fmt.Errorf("tls: no certificate in the chain of %s matches a pinned public key", cs.ServerName)
+	}
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTLSHost = "mycluster.dsql.us-east-1.on.aws"
+
+// testPKI is a root CA and a server certificate issued by it.
+type testPKI struct {
+	root   *x509.Certificate
+	server tls.Certificate
+}
+
+func newTestPKI(t *testing.T, serverName string) *testPKI {
+	t.Helper()
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	require.NoError(t, err)
+	root, err := x509.ParseCertificate(rootDER)
+	require.NoError(t, err)
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, root, &serverKey.PublicKey, rootKey)
+	require.NoError(t, err)
+
+	return &testPKI{
+		root:   root,
+		server: tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey},
+	}
+}
+
+func (p *testPKI) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(p.root)
+	return pool
+}
+
+// handshake runs a TLS handshake between cfg and a server presenting pki's
+// server certificate. A loopback listener is used rather than net.Pipe, since
+// both sides may write at once when the handshake fails.
+func handshake(t *testing.T, pki *testPKI, cfg *tls.Config) error {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{pki.server}})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), cfg)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestTLSConfigDefaults(t *testing.T) {
+	resolved, err := (&Config{Host: testTLSHost}).resolve()
+	require.NoError(t, err)
+	require.NoError(t, resolved.loadTLSConfig())
+
+	cfg := resolved.TLSConfig
+	assert.Equal(t, testTLSHost, cfg.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Nil(t, cfg.RootCAs)
+	assert.False(t, cfg.InsecureSkipVerify)
+	assert.Nil(t, cfg.VerifyConnection)
+}
+
+func TestTLSConfigVerification(t *testing.T) {
+	pki := newTestPKI(t, testTLSHost)
+	other := newTestPKI(t, testTLSHost)
+
+	tests := []struct {
+		name    string
+		tls     TLSConfig
+		server  *testPKI
+		wantErr string
+	}{
+		{
+			name:   "custom roots",
+			tls:    TLSConfig{RootCAs: pki.pool()},
+			server: pki,
+		},
+		{
+			name:    "untrusted server",
+			tls:     TLSConfig{RootCAs: pki.pool()},
+			server:  other,
+			wantErr: "certificate signed by unknown authority",
+		},
+		{
+			name:   "pinned root",
+			tls:    TLSConfig{RootCAs: pki.pool(), PinnedPublicKeys: []string{PublicKeyPin(other.root), PublicKeyPin(pki.root)}},
+			server: pki,
+		},
+		{
+			name:    "pin mismatch",
+			tls:     TLSConfig{RootCAs: pki.pool(), PinnedPublicKeys: []string{PublicKeyPin(other.root)}},
+			server:  pki,
+			wantErr: "matches a pinned public key",
+		},
+		{
+			name:    "Amazon roots",
+			tls:     TLSConfig{AmazonRoots: true},
+			server:  pki,
+			wantErr: "certificate signed by unknown authority",
+		},
+		{
+			name:   "TLS 1.3 minimum",
+			tls:    TLSConfig{RootCAs: pki.pool(), MinVersion: tls.VersionTLS13},
+			server: pki,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsOptions := tt.tls
+			resolved, err := (&Config{Host: testTLSHost, TLS: &tlsOptions}).resolve()
+			require.NoError(t, err)
+			require.NoError(t, resolved.loadTLSConfig())
+
+			err = handshake(t, tt.server, resolved.TLSConfig)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSConfigVerifyCA(t *testing.T) {
+	pki := newTestPKI(t, "other.dsql.us-east-1.on.aws")
+
+	resolved, err := (&Config{Host: testTLSHost, TLS: &TLSConfig{RootCAs: pki.pool()}}).resolve()
+	require.NoError(t, err)
+	require.NoError(t, resolved.loadTLSConfig())
+	err = handshake(t, pki, resolved.TLSConfig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate is valid for other.dsql.us-east-1.on.aws")
+
+	// verify-ca checks the chain but not the hostname.
+	resolved, err = (&Config{Host: testTLSHost, TLS: &TLSConfig{Mode: "verify-ca", RootCAs: pki.pool()}}).resolve()
+	require.NoError(t, err)
+	require.NoError(t, resolved.loadTLSConfig())
+	assert.NoError(t, handshake(t, pki, resolved.TLSConfig))
+
+	untrusted := newTestPKI(t, testTLSHost)
+	err = handshake(t, untrusted, resolved.TLSConfig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate signed by unknown authority")
+}
+
+func TestTLSConfigConnectionParams(t *testing.T) {
+	pki := newTestPKI(t, "other.dsql.us-east-1.on.aws")
+	rootFile := filepath.Join(t.TempDir(), "root.pem")
+	require.NoError(t, os.WriteFile(rootFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pki.root.Raw}), 0o600))
+
+	cfg, err := ParseConnectionString("postgres://admin@" + testTLSHost + "/postgres?sslmode=verify-ca&sslrootcert=" + rootFile)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"sslmode": "verify-ca", "sslrootcert": rootFile}, cfg.Params)
+
+	resolved, err := cfg.resolve()
+	require.NoError(t, err)
+	require.NoError(t, resolved.loadTLSConfig())
+	assert.True(t, resolved.TLSConfig.RootCAs.Equal(pki.pool()))
+	assert.NoError(t, handshake(t, pki, resolved.TLSConfig))
+
+	// Config.TLS takes precedence over the connection parameters.
+	cfg.TLS = &TLSConfig{Mode: "verify-full", RootCertFile: RootCertSystem}
+	resolved, err = cfg.resolve()
+	require.NoError(t, err)
+	require.NoError(t, resolved.loadTLSConfig())
+	assert.Nil(t, resolved.TLSConfig.RootCAs)
+	assert.False(t, resolved.TLSConfig.InsecureSkipVerify)
+
+	cfg, err = ParseConnectionString("host=" + testTLSHost + " sslrootcert=amazon")
+	require.NoError(t, err)
+	resolved, err = cfg.resolve()
+	require.NoError(t, err)
+	require.NoError(t, resolved.loadTLSConfig())
+	assert.Same(t, amazonRoots(), resolved.TLSConfig.RootCAs)
+
+	// The root certificate file is read when connecting, not when resolving.
+	missing := Config{Host: testTLSHost, Params: map[string]string{"sslrootcert": filepath.Join(t.TempDir(), "missing.pem")}}
+	resolved, err = missing.resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "dsql://"+testTLSHost+"?region=us-east-1&sslrootcert="+url.QueryEscape(missing.Params["sslrootcert"]), missing.String())
+	err = resolved.loadTLSConfig()
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = NewPool(context.Background(), missing)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	connStr, err := Config{Host: testTLSHost, TLS: &TLSConfig{Mode: "verify-ca", AmazonRoots: true}}.ConnectionString()
+	require.NoError(t, err)
+	assert.Equal(t, "dsql://"+testTLSHost+"?region=us-east-1&sslmode=verify-ca&sslrootcert=amazon", connStr)
+}
+
+func TestAmazonRoots(t *testing.T) {
+	var subjects []string
+	rest := amazonRootsPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		assert.True(t, cert.IsCA)
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+	assert.Equal(t, []string{
+		"Amazon Root CA 1",
+		"Amazon Root CA 2",
+		"Amazon Root CA 3",
+		"Amazon Root CA 4",
+		"Starfield Services Root Certificate Authority - G2",
+	}, subjects)
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	err := Config{Host: testTLSHost, TLS: &TLSConfig{
+		Mode:             "disable",
+		RootCertFile:     "/etc/ssl/root.pem",
+		AmazonRoots:      true,
+		PinnedPublicKeys: []string{"not-a-pin"},
+		MinVersion:       tls.VersionTLS11,
+	}}.Validate()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	var fieldErr *FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "TLS", fieldErr.Field)
+	for _, msg := range []string{
+		`sslmode "disable" is not supported`,
+		"only one of RootCAs, RootCertFile and AmazonRoots may be set",
+		`pinned public key "not-a-pin"`,
+		"minimum TLS version must be TLS 1.2 or TLS 1.3, got TLS 1.1",
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+}
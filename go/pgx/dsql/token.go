@@ -7,44 +7,306 @@ package dsql
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/feature/dsql/auth"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
 )
 
 const adminUser = "admin"
 
+// SigV4 parameters of DSQL authentication tokens.
+const (
+	signingName      = "dsql"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// IAM actions authorized by DSQL authentication tokens.
+const (
+	actionDbConnect      = "DbConnect"
+	actionDbConnectAdmin = "DbConnectAdmin"
+)
+
+// tokenAction returns the IAM action that a token for user authorizes when the
+// token action is TokenActionAuto.
+func tokenAction(user string) string {
+	if user == adminUser {
+		return actionDbConnectAdmin
+	}
+	return actionDbConnect
+}
+
+// TokenAction selects the IAM action that authentication tokens authorize.
+type TokenAction string
+
+const (
+	// TokenActionAuto authorizes DbConnectAdmin for the "admin" user and
+	// DbConnect for every other user.
+	TokenActionAuto TokenAction = ""
+
+	// TokenActionAdmin always authorizes DbConnectAdmin, for example when the
+	// admin role is reached through a proxy user name.
+	TokenActionAdmin TokenAction = "admin"
+
+	// TokenActionStandard always authorizes DbConnect.
+	TokenActionStandard TokenAction = "standard"
+)
+
+// validTokenAction reports whether a is a known token action.
+func validTokenAction(a TokenAction) bool {
+	switch a {
+	case TokenActionAuto, TokenActionAdmin, TokenActionStandard:
+		return true
+	}
+	return false
+}
+
+// iamAction returns the IAM action that a token for user authorizes.
+func (a TokenAction) iamAction(user string) string {
+	switch a {
+	case TokenActionAdmin:
+		return actionDbConnectAdmin
+	case TokenActionStandard:
+		return actionDbConnect
+	default:
+		return tokenAction(user)
+	}
+}
+
+// TokenProvider produces IAM authentication tokens for DSQL connections.
+//
+// Token returns the token to use as the connection password for user on the
+// cluster at host in region, along with the time at which the token expires.
+// A zero expiry time means the expiry is unknown.
+//
+// Set Config.TokenProvider to obtain tokens from a source other than the
+// built-in SigV4 generator, such as a sidecar, a broker service or a test fake.
+type TokenProvider interface {
+	Token(ctx context.Context, host, region, user string) (string, time.Time, error)
+}
+
+// sigV4TokenProvider is the default TokenProvider. It presigns tokens locally
+// using AWS credentials.
+type sigV4TokenProvider struct {
+	credentialsProvider aws.CredentialsProvider
+	source              CredentialSource // reported in CredentialsError
+	duration            time.Duration
+	action              TokenAction
+
+	// clock, if set, supplies the skew-corrected signing time. timeSource and
+	// onSkew are used to measure the skew before signing.
+	clock      *skewClock
+	timeSource TimeSource
+	onSkew     func(time.Duration)
+}
+
+// NewSigV4TokenProvider returns a TokenProvider that generates tokens with
+// [GenerateToken] using the given credentials provider and token duration.
+// If credentialsProvider is nil, the default AWS credential chain is used.
+// If duration is 0, the AWS SDK default is used.
+func NewSigV4TokenProvider(credentialsProvider aws.CredentialsProvider, duration time.Duration) TokenProvider {
+	return &sigV4TokenProvider{
+		credentialsProvider: credentialsProvider,
+		source:              providedCredentialSource(credentialsProvider),
+		duration:            duration,
+	}
+}
+
+// newSigV4TokenProvider returns the SigV4 token provider for r, signing with
+// clock when clock skew compensation is enabled.
+func (r *resolvedConfig) newSigV4TokenProvider(credentialsProvider aws.CredentialsProvider, clock *skewClock) *sigV4TokenProvider {
+	return &sigV4TokenProvider{
+		credentialsProvider: credentialsProvider,
+		source:              r.effectiveCredentialSource(),
+		duration:            r.TokenDuration,
+		action:              r.TokenAction,
+		clock:               clock,
+		timeSource:          r.TimeSource,
+		onSkew:              r.OnClockSkew,
+	}
+}
+
+func (p *sigV4TokenProvider) Token(ctx context.Context, host, region, user string) (string, time.Time, error) {
+	var signingTime time.Time
+	if p.clock != nil {
+		if p.timeSource != nil {
+			if err := p.clock.measure(ctx, p.timeSource, p.onSkew); err != nil {
+				return "", time.Time{}, &TokenError{Host: host, Region: region, User: user, Err: err}
+			}
+		}
+		if p.clock.offset() != 0 {
+			signingTime = p.clock.Now()
+		}
+	}
+
+	// The expiry is reported in local time, so that it can be compared with
+	// the local clock regardless of the signing time.
+	issuedAt := time.Now()
+	token, err := generateToken(ctx, host, region, user, p.action, p.credentialsProvider, p.source, p.duration, signingTime)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// The SDK shortens the token lifetime when the credentials expire sooner
+	// than the requested duration, so prefer the lifetime encoded in the token.
+	lifetime, err := tokenLifetime(token)
+	if err != nil {
+		lifetime = p.duration
+	}
+	return token, issuedAt.Add(lifetime), nil
+}
+
+// amzDateFormat is the layout of the X-Amz-Date query parameter in a presigned token.
+const amzDateFormat = "20060102T150405Z"
+
+// tokenQuery returns the query parameters of a presigned token.
+func tokenQuery(token string) (url.Values, error) {
+	_, rawQuery, ok := strings.Cut(token, "?")
+	if !ok {
+		return nil, fmt.Errorf("token has no query string")
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token query string: %w", err)
+	}
+	return query, nil
+}
+
+// Invalidate discards cached credentials so that the next token is signed with
+// freshly retrieved ones, and schedules a new clock skew measurement.
+func (p *sigV4TokenProvider) Invalidate() {
+	if inv, ok := p.credentialsProvider.(invalidator); ok {
+		inv.Invalidate()
+	}
+	if p.clock != nil {
+		p.clock.Invalidate()
+	}
+}
+
+// tokenLifetime returns the validity duration encoded in a presigned token.
+func tokenLifetime(token string) (time.Duration, error) {
+	query, err := tokenQuery(token)
+	if err != nil {
+		return 0, err
+	}
+	expiresSecs, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid X-Amz-Expires: %w", err)
+	}
+	return time.Duration(expiresSecs) * time.Second, nil
+}
+
+// tokenExpiresAt returns the absolute expiry time encoded in a presigned token.
+func tokenExpiresAt(token string) (time.Time, error) {
+	query, err := tokenQuery(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	signedAt, err := time.Parse(amzDateFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid X-Amz-Date: %w", err)
+	}
+	lifetime, err := tokenLifetime(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return signedAt.Add(lifetime), nil
+}
+
 // resolveCredentialsProvider resolves the AWS credentials provider once based on the configuration.
 // This avoids repeated credential resolution on each token generation.
 func resolveCredentialsProvider(ctx context.Context, resolved *resolvedConfig) (aws.CredentialsProvider, error) {
-	// If custom provider is specified, use it directly
-	if resolved.CustomCredentialsProvider != nil {
+	source := resolved.effectiveCredentialSource()
+
+	// If custom provider is specified without a role chain, use it directly
+	if source == CredentialSourceCustom && len(resolved.AssumeRole) == 0 {
 		return resolved.CustomCredentialsProvider, nil
 	}
 
-	// If profile is specified, load config with that profile
-	if resolved.Profile != "" {
-		cfg, err := config.LoadDefaultConfig(ctx,
-			config.WithRegion(resolved.Region),
-			config.WithSharedConfigProfile(resolved.Profile),
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load AWS config with profile %s: %w", resolved.Profile, err)
+	profile := ""
+	if source == CredentialSourceProfile {
+		profile = resolved.Profile
+		if len(resolved.AssumeRole) > 0 && resolved.AssumeRole[0].SourceProfile != "" {
+			profile = resolved.AssumeRole[0].SourceProfile
 		}
-		return cfg.Credentials, nil
 	}
 
-	// Use default credential chain
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(resolved.Region))
+	loadOpts := []func(*config.LoadOptions) error{config.WithRegion(resolved.Region)}
+	if profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(profile))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		if profile != "" {
+			return nil, &CredentialsError{Source: source, Err: fmt.Errorf("failed to load AWS config with profile %s: %w", profile, err)}
+		}
+		return nil, &CredentialsError{Source: source, Err: fmt.Errorf("failed to load AWS config: %w", err)}
+	}
+
+	base, err := resolved.baseCredentialsProvider(cfg, source)
+	if err != nil {
+		return nil, &CredentialsError{Source: source, Err: err}
+	}
+	cfg.Credentials = base
+
+	return assumeRoleChain(cfg, resolved.AssumeRole), nil
+}
+
+// assumeRoleChain returns a credentials provider that assumes each role in hops
+// in turn, starting from the credentials in cfg. If hops is empty, the
+// credentials in cfg are returned unchanged.
+func assumeRoleChain(cfg aws.Config, hops []AssumeRoleOptions) aws.CredentialsProvider {
+	creds := cfg.Credentials
+	for _, hop := range hops {
+		hopCfg := cfg.Copy()
+		hopCfg.Credentials = creds
+		stsClient := sts.NewFromConfig(hopCfg)
+
+		creds = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, hop.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			if hop.ExternalID != "" {
+				o.ExternalID = aws.String(hop.ExternalID)
+			}
+			if hop.SessionName != "" {
+				o.RoleSessionName = hop.SessionName
+			}
+			if hop.Duration > 0 {
+				o.Duration = hop.Duration
+			}
+			o.Tags = sessionTags(hop.SessionTags)
+		}))
+	}
+	return creds
+}
+
+// sessionTags converts a tag map to STS session tags in key order.
+func sessionTags(tags map[string]string) []types.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]types.Tag, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, types.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
 	}
-	return cfg.Credentials, nil
+	return result
 }
 
 // GenerateToken generates an IAM authentication token for Aurora DSQL.
@@ -58,19 +320,85 @@ func GenerateToken(
 	user string,
 	credentialsProvider aws.CredentialsProvider,
 	expiry time.Duration,
+) (string, error) {
+	return generateToken(ctx, host, region, user, TokenActionAuto, credentialsProvider, providedCredentialSource(credentialsProvider), expiry, time.Time{})
+}
+
+// GenerateTokenWithAction is like GenerateToken but authorizes the IAM action
+// selected by action instead of inferring it from user.
+func GenerateTokenWithAction(
+	ctx context.Context,
+	host string,
+	region string,
+	user string,
+	action TokenAction,
+	credentialsProvider aws.CredentialsProvider,
+	expiry time.Duration,
+) (string, error) {
+	if !validTokenAction(action) {
+		return "", &TokenError{Host: host, Region: region, User: user, Err: fmt.Errorf("unknown token action %q", action)}
+	}
+	return generateToken(ctx, host, region, user, action, credentialsProvider, providedCredentialSource(credentialsProvider), expiry, time.Time{})
+}
+
+// GenerateTokenAt is like GenerateToken but signs the token as of signingTime,
+// for example the local time corrected for a known clock skew.
+// If signingTime is zero, the current time is used.
+func GenerateTokenAt(
+	ctx context.Context,
+	host string,
+	region string,
+	user string,
+	credentialsProvider aws.CredentialsProvider,
+	expiry time.Duration,
+	signingTime time.Time,
+) (string, error) {
+	return generateToken(ctx, host, region, user, TokenActionAuto, credentialsProvider, providedCredentialSource(credentialsProvider), expiry, signingTime)
+}
+
+// providedCredentialSource returns the credential source reported for a
+// credentials provider passed to the exported token functions.
+func providedCredentialSource(credentialsProvider aws.CredentialsProvider) CredentialSource {
+	if credentialsProvider == nil {
+		return CredentialSourceDefault
+	}
+	return CredentialSourceCustom
+}
+
+func generateToken(
+	ctx context.Context,
+	host string,
+	region string,
+	user string,
+	action TokenAction,
+	credentialsProvider aws.CredentialsProvider,
+	source CredentialSource,
+	expiry time.Duration,
+	signingTime time.Time,
 ) (string, error) {
 	var creds aws.CredentialsProvider
 
 	if credentialsProvider != nil {
 		creds = credentialsProvider
 	} else {
+		source = CredentialSourceDefault
 		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
 		if err != nil {
-			return "", fmt.Errorf("failed to load AWS config: %w", err)
+			return "", &CredentialsError{Source: source, Err: fmt.Errorf("failed to load AWS config: %w", err)}
 		}
 		creds = cfg.Credentials
 	}
 
+	// Retrieve credentials up front so that credential failures can be told
+	// apart from signing failures.
+	retrieved, err := creds.Retrieve(ctx)
+	if err != nil {
+		return "", &CredentialsError{Source: source, Err: err}
+	}
+	signingCreds := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return retrieved, nil
+	})
+
 	var tokenOpts []func(*auth.TokenOptions)
 	if expiry > 0 {
 		tokenOpts = append(tokenOpts, func(opts *auth.TokenOptions) {
@@ -79,25 +407,61 @@ func GenerateToken(
 	}
 
 	var token string
-	var err error
+	iamAction := action.iamAction(user)
 
-	if user == adminUser {
-		token, err = auth.GenerateDBConnectAdminAuthToken(ctx, host, region, creds, tokenOpts...)
+	if !signingTime.IsZero() {
+		token, err = presignToken(ctx, host, region, iamAction, retrieved, expiry, signingTime)
+	} else if iamAction == actionDbConnectAdmin {
+		token, err = auth.GenerateDBConnectAdminAuthToken(ctx, host, region, signingCreds, tokenOpts...)
 	} else {
-		token, err = auth.GenerateDbConnectAuthToken(ctx, host, region, creds, tokenOpts...)
+		token, err = auth.GenerateDbConnectAuthToken(ctx, host, region, signingCreds, tokenOpts...)
 	}
 
 	if err != nil {
-		return "", fmt.Errorf("failed to generate auth token: %w", err)
+		return "", &TokenError{Host: host, Region: region, User: user, Err: err}
 	}
 
 	if token == "" {
-		return "", fmt.Errorf("generated auth token is empty")
+		return "", &TokenError{Host: host, Region: region, User: user, Err: errors.New("generated auth token is empty")}
 	}
 
 	return token, nil
 }
 
+// presignToken presigns a token as of signingTime. It mirrors the AWS SDK's DSQL
+// token generator, which always signs with the current time.
+func presignToken(
+	ctx context.Context,
+	host string,
+	region string,
+	action string,
+	creds aws.Credentials,
+	expiry time.Duration,
+	signingTime time.Time,
+) (string, error) {
+	if expiry <= 0 {
+		expiry = DefaultTokenDuration
+	}
+	if creds.CanExpire && !creds.Expires.IsZero() {
+		expiry = min(expiry, creds.Expires.Sub(signingTime))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://"+host, nil)
+	if err != nil {
+		return "", err
+	}
+	req.URL.RawQuery = url.Values{
+		"Action":        {action},
+		"X-Amz-Expires": {strconv.Itoa(int(expiry.Seconds()))},
+	}.Encode()
+
+	signed, _, err := v4.NewSigner().PresignHTTP(ctx, creds, req, emptyPayloadHash, signingName, region, signingTime.UTC())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(signed, "https://"), nil
+}
+
 // GenerateTokenWithProfile generates an IAM authentication token using a specific AWS profile.
 func GenerateTokenWithProfile(
 	ctx context.Context,
@@ -112,7 +476,7 @@ func GenerateTokenWithProfile(
 		config.WithSharedConfigProfile(profile),
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to load AWS config with profile %s: %w", profile, err)
+		return "", &CredentialsError{Source: CredentialSourceProfile, Err: fmt.Errorf("failed to load AWS config with profile %s: %w", profile, err)}
 	}
 
 	return GenerateToken(ctx, host, region, user, cfg.Credentials, expiry)
@@ -122,7 +486,7 @@ func GenerateTokenWithProfile(
 func NewAssumeRoleCredentialsProvider(ctx context.Context, roleARN, region string) (aws.CredentialsProvider, error) {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
 	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		return nil, &CredentialsError{Source: CredentialSourceDefault, Err: fmt.Errorf("failed to load AWS config: %w", err)}
 	}
 
 	stsClient := sts.NewFromConfig(cfg)
@@ -133,6 +497,10 @@ func NewAssumeRoleCredentialsProvider(ctx context.Context, roleARN, region strin
 // This is useful for use with database/sql drivers that don't use the dsql.NewPool or dsql.Connect helpers.
 // The connection string should be in the format: dsql://user@host/database or postgres://user@host/database
 //
+// The token is always presigned with SigV4 from the credentials the connection
+// string selects. A connection string cannot carry a Config.TokenProvider, so
+// none is consulted; call the provider's Token method directly instead.
+//
 // Example usage:
 //
 //	token, err := dsql.GenerateTokenConnString(ctx, "dsql://admin@cluster.dsql.us-east-1.on.aws/postgres")
@@ -160,5 +528,5 @@ func GenerateTokenConnString(ctx context.Context, connStr string) (string, error
 	}
 
 	// Generate token
-	return GenerateToken(ctx, resolved.Host, resolved.Region, resolved.User, credentialsProvider, resolved.TokenDuration)
+	return GenerateTokenWithAction(ctx, resolved.Host, resolved.Region, resolved.User, resolved.TokenAction, credentialsProvider, resolved.TokenDuration)
 }
@@ -0,0 +1,164 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenCache is a [TokenProvider] that caches tokens from another provider and
+// refreshes them before they expire.
+//
+// Tokens are keyed by host, region, user and token action. A cached token is
+// returned as-is until the refresh fraction of its lifetime has elapsed; after that
+// the cached token is still returned while a replacement is generated in the
+// background. Once a token has expired, callers block until a new one is generated.
+// Concurrent callers requesting the same missing token share a single generation.
+// Tokens returned with a zero expiry time are not cached.
+//
+// A TokenCache is safe for concurrent use.
+type TokenCache struct {
+	provider        TokenProvider
+	refreshFraction float64
+
+	// now returns the current time. Overridden in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[tokenCacheKey]*tokenCacheEntry
+}
+
+type tokenCacheKey struct {
+	host   string
+	region string
+	user   string
+	action string
+}
+
+type tokenCacheEntry struct {
+	// genMu serializes synchronous generation so that a burst of callers
+	// for the same key performs a single presign.
+	genMu sync.Mutex
+
+	// The fields below are guarded by TokenCache.mu.
+	token      string
+	refreshAt  time.Time
+	expiresAt  time.Time
+	refreshing bool
+}
+
+// NewTokenCache creates a token cache in front of provider.
+// If refreshFraction is not in the range (0, 1], DefaultTokenRefreshFraction is used.
+func NewTokenCache(provider TokenProvider, refreshFraction float64) *TokenCache {
+	if refreshFraction <= 0 || refreshFraction > 1 {
+		refreshFraction = DefaultTokenRefreshFraction
+	}
+	return &TokenCache{
+		provider:        provider,
+		refreshFraction: refreshFraction,
+		now:             time.Now,
+		entries:         make(map[tokenCacheKey]*tokenCacheEntry),
+	}
+}
+
+// Token returns a valid token for the given host, region and user, generating
+// one if no usable token is cached.
+func (c *TokenCache) Token(ctx context.Context, host, region, user string) (string, time.Time, error) {
+	key := tokenCacheKey{host: host, region: region, user: user, action: tokenAction(user)}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &tokenCacheEntry{}
+		c.entries[key] = entry
+	}
+	if token, expiresAt, ok := c.cachedLocked(key, entry); ok {
+		c.mu.Unlock()
+		return token, expiresAt, nil
+	}
+	c.mu.Unlock()
+
+	entry.genMu.Lock()
+	defer entry.genMu.Unlock()
+
+	// Another caller may have generated the token while we were waiting.
+	c.mu.Lock()
+	if token, expiresAt, ok := c.cachedLocked(key, entry); ok {
+		c.mu.Unlock()
+		return token, expiresAt, nil
+	}
+	c.mu.Unlock()
+
+	return c.generate(ctx, key, entry)
+}
+
+// Invalidate discards all cached tokens so that the next call to Token
+// generates a fresh one. If the underlying provider has an Invalidate method,
+// it is called as well.
+func (c *TokenCache) Invalidate() {
+	c.mu.Lock()
+	c.entries = make(map[tokenCacheKey]*tokenCacheEntry)
+	c.mu.Unlock()
+
+	invalidateTokenProvider(c.provider)
+}
+
+// cachedLocked returns the cached token for entry if it has not expired,
+// starting a background refresh when it is past its refresh time.
+// c.mu must be held.
+func (c *TokenCache) cachedLocked(key tokenCacheKey, entry *tokenCacheEntry) (string, time.Time, bool) {
+	if entry.token == "" {
+		return "", time.Time{}, false
+	}
+	now := c.now()
+	if !now.Before(entry.expiresAt) {
+		return "", time.Time{}, false
+	}
+	if !now.Before(entry.refreshAt) && !entry.refreshing {
+		entry.refreshing = true
+		go c.refresh(key, entry)
+	}
+	return entry.token, entry.expiresAt, true
+}
+
+// refresh regenerates the token for entry in the background. Failures are
+// ignored; the cached token remains in use until it expires, at which point
+// Token generates synchronously and reports the error to the caller.
+func (c *TokenCache) refresh(key tokenCacheKey, entry *tokenCacheEntry) {
+	entry.genMu.Lock()
+	defer entry.genMu.Unlock()
+
+	_, _, _ = c.generate(context.Background(), key, entry)
+
+	c.mu.Lock()
+	entry.refreshing = false
+	c.mu.Unlock()
+}
+
+// generate obtains a new token from the provider and stores it in entry.
+// entry.genMu must be held.
+func (c *TokenCache) generate(ctx context.Context, key tokenCacheKey, entry *tokenCacheEntry) (string, time.Time, error) {
+	issuedAt := c.now()
+	token, expiresAt, err := c.provider.Token(ctx, key.host, key.region, key.user)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if expiresAt.IsZero() {
+		return token, expiresAt, nil
+	}
+
+	lifetime := expiresAt.Sub(issuedAt)
+
+	c.mu.Lock()
+	entry.token = token
+	entry.expiresAt = expiresAt
+	entry.refreshAt = issuedAt.Add(time.Duration(float64(lifetime) * c.refreshFraction))
+	c.mu.Unlock()
+
+	return token, expiresAt, nil
+}
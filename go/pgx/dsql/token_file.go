@@ -0,0 +1,96 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileTokenProvider is a [TokenProvider] that reads a token from a file, such as
+// one written to a shared volume by a Kubernetes sidecar.
+//
+// The file is read on every call, and its token is parsed again whenever the
+// contents change, so new connections always use the latest token without a
+// restart, even when a rewrite keeps the modification time and size. Token files
+// are small, and the read is negligible next to the connection handshake.
+// Symlinks are followed, which supports the atomic symlink swaps used
+// by Kubernetes projected volumes. Leading and trailing whitespace is ignored.
+//
+// The reported expiry is taken from the token itself when it is a presigned DSQL
+// token; otherwise it is the zero time. The host, region and user arguments are
+// ignored: the file is expected to contain a token for the configured cluster.
+type FileTokenProvider struct {
+	path string
+
+	// now returns the current time. Overridden in tests.
+	now func() time.Time
+
+	mu        sync.Mutex
+	contents  string
+	token     string
+	expiresAt time.Time
+}
+
+// NewFileTokenProvider creates a FileTokenProvider that reads the token at path.
+// The file does not need to exist until the first connection is made.
+func NewFileTokenProvider(path string) *FileTokenProvider {
+	return &FileTokenProvider{
+		path: path,
+		now:  time.Now,
+	}
+}
+
+// Token returns the token currently stored in the file.
+func (p *FileTokenProvider) Token(ctx context.Context, host, region, user string) (string, time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tokenErr := func(err error) error {
+		return &TokenError{Host: host, Region: region, User: user, Err: err}
+	}
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", time.Time{}, tokenErr(fmt.Errorf("failed to read token file: %w", err))
+	}
+
+	if p.token == "" || string(data) != p.contents {
+		if err := p.parseLocked(string(data)); err != nil {
+			return "", time.Time{}, tokenErr(err)
+		}
+	}
+
+	if !p.expiresAt.IsZero() && !p.now().Before(p.expiresAt) {
+		return "", time.Time{}, tokenErr(fmt.Errorf("token in %s expired at %s", p.path, p.expiresAt.Format(time.RFC3339)))
+	}
+
+	return p.token, p.expiresAt, nil
+}
+
+// parseLocked replaces the cached token with the one in contents, the contents
+// of the token file. p.mu must be held.
+func (p *FileTokenProvider) parseLocked(contents string) error {
+	token := strings.TrimSpace(contents)
+	if token == "" {
+		return fmt.Errorf("token file %s is empty", p.path)
+	}
+
+	expiresAt, err := tokenExpiresAt(token)
+	if err != nil {
+		// Not a presigned DSQL token; the expiry is unknown.
+		expiresAt = time.Time{}
+	}
+
+	p.contents = contents
+	p.token = token
+	p.expiresAt = expiresAt
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTokenFile writes token to path with the given modification time.
+func writeTokenFile(t *testing.T, path, token string, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(token), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestFileTokenProviderReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	provider := NewFileTokenProvider(path)
+	ctx := context.Background()
+
+	modTime := time.Now().Add(-time.Minute)
+	writeTokenFile(t, path, "first-token\n", modTime)
+
+	token, expiresAt, err := provider.Token(ctx, testHost, "us-east-1", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, "first-token", token)
+	assert.True(t, expiresAt.IsZero())
+
+	writeTokenFile(t, path, "second-token\n", modTime.Add(time.Second))
+
+	token, _, err = provider.Token(ctx, testHost, "us-east-1", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, "second-token", token)
+
+	// A rewrite that keeps the modification time and size is still picked up.
+	writeTokenFile(t, path, "latest-token\n", modTime.Add(time.Second))
+
+	token, _, err = provider.Token(ctx, testHost, "us-east-1", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, "latest-token", token)
+}
+
+func TestFileTokenProviderReportsExpiry(t *testing.T) {
+	var calls atomic.Int32
+	generated, err := GenerateToken(context.Background(), testHost, "us-east-1", "admin", countingCredentials(&calls), 5*time.Minute)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "token")
+	writeTokenFile(t, path, generated, time.Now())
+
+	provider := NewFileTokenProvider(path)
+	token, expiresAt, err := provider.Token(context.Background(), testHost, "us-east-1", "admin")
+	require.NoError(t, err)
+
+	assert.Equal(t, generated, token)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), expiresAt, 2*time.Second)
+
+	provider.now = func() time.Time { return expiresAt }
+	_, _, err = provider.Token(context.Background(), testHost, "us-east-1", "admin")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expired")
+}
+
+func TestFileTokenProviderErrors(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	_, _, err := NewFileTokenProvider(filepath.Join(dir, "missing")).Token(ctx, testHost, "us-east-1", "admin")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read token file")
+
+	empty := filepath.Join(dir, "empty")
+	writeTokenFile(t, empty, "  \n", time.Now())
+	_, _, err = NewFileTokenProvider(empty).Token(ctx, testHost, "us-east-1", "admin")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is empty")
+}
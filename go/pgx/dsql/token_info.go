@@ -0,0 +1,137 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dsql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenInfo describes the contents of a DSQL authentication token.
+type TokenInfo struct {
+	// Host is the cluster endpoint the token was generated for.
+	Host string
+
+	// Region is the AWS region in the token's credential scope.
+	Region string
+
+	// Service is the signing service in the token's credential scope, "dsql"
+	// for DSQL tokens.
+	Service string
+
+	// Action is the IAM action the token authorizes, "DbConnect" or "DbConnectAdmin".
+	Action string
+
+	// AccessKeyID is the access key ID of the credentials that signed the token.
+	AccessKeyID string
+
+	// IssuedAt is the signing time of the token.
+	IssuedAt time.Time
+
+	// Duration is the validity duration of the token.
+	Duration time.Duration
+
+	// ExpiresAt is the time at which the token expires.
+	ExpiresAt time.Time
+
+	// now returns the current time. Overridden in tests.
+	now func() time.Time
+}
+
+// ParseToken parses a token produced by [GenerateToken] without network access.
+// It does not verify the token's signature.
+func ParseToken(token string) (*TokenInfo, error) {
+	host, _, ok := strings.Cut(token, "?")
+	if !ok || host == "" {
+		return nil, errors.New("invalid token: expected a presigned URL of the form host?query")
+	}
+
+	query, err := tokenQuery(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	// The credential is access-key-id/date/region/service/aws4_request.
+	scope := strings.Split(query.Get("X-Amz-Credential"), "/")
+	if len(scope) != 5 || scope[0] == "" || scope[4] != "aws4_request" {
+		return nil, fmt.Errorf("invalid token: malformed X-Amz-Credential %q", query.Get("X-Amz-Credential"))
+	}
+
+	issuedAt, err := time.Parse(amzDateFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: invalid X-Amz-Date: %w", err)
+	}
+
+	duration, err := tokenLifetime(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	action := query.Get("Action")
+	if action == "" {
+		return nil, errors.New("invalid token: missing Action")
+	}
+
+	return &TokenInfo{
+		Host:        strings.TrimSuffix(host, "/"),
+		Region:      scope[2],
+		Service:     scope[3],
+		Action:      action,
+		AccessKeyID: scope[0],
+		IssuedAt:    issuedAt,
+		Duration:    duration,
+		ExpiresAt:   issuedAt.Add(duration),
+		now:         time.Now,
+	}, nil
+}
+
+// Remaining returns how long the token remains valid, or 0 if it has expired.
+func (i *TokenInfo) Remaining() time.Duration {
+	now := time.Now
+	if i.now != nil {
+		now = i.now
+	}
+	if remaining := i.ExpiresAt.Sub(now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Expired reports whether the token has expired.
+func (i *TokenInfo) Expired() bool {
+	return i.Remaining() == 0
+}
+
+// Match reports whether the token was generated for the cluster, region and
+// user of config, returning an error describing every mismatch.
+// The config parameter can be a Config struct, *Config, or a connection string.
+func (i *TokenInfo) Match(config any) error {
+	cfg, err := toConfig(config)
+	if err != nil {
+		return err
+	}
+	resolved, err := cfg.resolve()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	if !strings.EqualFold(i.Host, resolved.Host) {
+		errs = append(errs, fmt.Errorf("token host %q does not match %q", i.Host, resolved.Host))
+	}
+	if i.Region != resolved.Region {
+		errs = append(errs, fmt.Errorf("token region %q does not match %q", i.Region, resolved.Region))
+	}
+	if i.Service != signingName {
+		errs = append(errs, fmt.Errorf("token service %q is not %q", i.Service, signingName))
+	}
+	if action := resolved.TokenAction.iamAction(resolved.User); i.Action != action {
+		errs = append(errs, fmt.Errorf("token action %q does not match %q required for user %q", i.Action, action, resolved.User))
+	}
+	return errors.Join(errs...)
+}
@@ -11,17 +11,138 @@ import (
 	"strings"
 )
 
+// EndpointVariant selects the form of the cluster endpoint built from a
+// cluster ID or ARN.
+type EndpointVariant string
+
 const (
-	preRegionHostPattern  = ".dsql."
-	postRegionHostPattern = ".on.aws"
+	// EndpointStandard is the standard IPv4 endpoint, such as
+	// <id>.dsql.us-east-1.on.aws.
+	EndpointStandard EndpointVariant = ""
+
+	// EndpointFIPS is the FIPS 140 validated endpoint, such as
+	// <id>.dsql-fips.us-east-1.on.aws.
+	EndpointFIPS EndpointVariant = "fips"
+
+	// EndpointDualStack is the IPv4 and IPv6 endpoint, such as
+	// <id>.dsql.us-east-1.api.aws.
+	EndpointDualStack EndpointVariant = "dualstack"
+
+	// EndpointFIPSDualStack is the FIPS endpoint with IPv4 and IPv6, such as
+	// <id>.dsql-fips.us-east-1.api.aws.
+	EndpointFIPSDualStack EndpointVariant = "fips-dualstack"
 )
 
-var regionPattern = regexp.MustCompile(`\.dsql[^.]*\.([^.]+)\.on\.aws$`)
+// validEndpointVariant reports whether v is a known endpoint variant.
+func validEndpointVariant(v EndpointVariant) bool {
+	switch v {
+	case EndpointStandard, EndpointFIPS, EndpointDualStack, EndpointFIPSDualStack:
+		return true
+	}
+	return false
+}
+
+func (v EndpointVariant) fips() bool {
+	return v == EndpointFIPS || v == EndpointFIPSDualStack
+}
+
+func (v EndpointVariant) dualStack() bool {
+	return v == EndpointDualStack || v == EndpointFIPSDualStack
+}
+
+// partition describes the DSQL endpoints of an AWS partition. A partition
+// without a dnsSuffix has no known DSQL endpoints, and one without a
+// dualStackDNSSuffix has no known dual-stack endpoints.
+type partition struct {
+	name               string
+	regionPattern      *regexp.Regexp
+	dnsSuffix          string
+	dualStackDNSSuffix string
+	fips               bool
+}
+
+// partitions lists the AWS partitions. Names and region patterns follow the
+// partition metadata of the AWS SDK (internal/endpoints/awsrulesfn/partitions.json
+// in aws-sdk-go-v2). The FIPS and dual-stack endpoints of the commercial
+// partition are those listed in the Aurora DSQL User Guide; GovCloud regions
+// are given the standard endpoint form only, and no DSQL endpoints are known in
+// the China partition. Regions matching no pattern are assumed to be in the
+// first, commercial partition.
+var partitions = []partition{
+	{
+		name:               "aws",
+		regionPattern:      regexp.MustCompile(`^(us|eu|ap|sa|ca|me|af|il|mx)-[a-z]+-[0-9]+$`),
+		dnsSuffix:          "on.aws",
+		dualStackDNSSuffix: "api.aws",
+		fips:               true,
+	},
+	{
+		name:          "aws-us-gov",
+		regionPattern: regexp.MustCompile(`^us-gov-[a-z]+-[0-9]+$`),
+		dnsSuffix:     "on.aws",
+	},
+	{
+		name:          "aws-cn",
+		regionPattern: regexp.MustCompile(`^cn-[a-z]+-[0-9]+$`),
+	},
+}
+
+// partitionForRegion returns the partition containing region.
+func partitionForRegion(region string) *partition {
+	for i := range partitions {
+		if partitions[i].regionPattern.MatchString(region) {
+			return &partitions[i]
+		}
+	}
+	return &partitions[0]
+}
+
+// PartitionForRegion returns the name of the AWS partition containing region,
+// such as "aws", "aws-us-gov" or "aws-cn".
+func PartitionForRegion(region string) string {
+	return partitionForRegion(region).name
+}
+
+// knownPartition reports whether name is a partition in the partition table.
+func knownPartition(name string) bool {
+	for _, p := range partitions {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// regionPattern matches DSQL cluster hostnames of every partition and endpoint
+// variant, capturing the region.
+var regionPattern = func() *regexp.Regexp {
+	var suffixes []string
+	seen := make(map[string]bool)
+	for _, p := range partitions {
+		for _, suffix := range []string{p.dnsSuffix, p.dualStackDNSSuffix} {
+			if suffix != "" && !seen[suffix] {
+				seen[suffix] = true
+				suffixes = append(suffixes, regexp.QuoteMeta(suffix))
+			}
+		}
+	}
+	return regexp.MustCompile(`\.dsql[^.]*\.([^.]+)\.(?:` + strings.Join(suffixes, "|") + `)$`)
+}()
+
+// vpcEndpointPattern matches the DNS names of interface VPC endpoints, such as
+// vpce-0123456789abcdef0-abcdefgh.dsql-fnh4.us-east-1.vpce.amazonaws.com,
+// capturing the region.
+var vpcEndpointPattern = regexp.MustCompile(`^(?:\*\.)?vpce-[^.]+\.[^.]+\.([^.]+)\.vpce\.amazonaws\.com(?:\.cn)?$`)
+
+// validRegionPattern matches AWS region names such as us-east-1, us-gov-west-1
+// or eusc-de-east-1.
+var validRegionPattern = regexp.MustCompile(`^[a-z]+(-[a-z]+)+-[0-9]+$`)
 
 // clusterIDPattern validates DSQL cluster IDs: 26 lowercase alphanumeric characters
 var clusterIDPattern = regexp.MustCompile(`^[a-z0-9]{26}$`)
 
-// ParseRegion extracts the AWS region from a DSQL hostname.
+// ParseRegion extracts the AWS region from a DSQL hostname or the DNS name of an
+// interface VPC endpoint for DSQL.
 // Returns an error if the hostname is empty or doesn't match the expected pattern.
 func ParseRegion(host string) (string, error) {
 	if host == "" {
@@ -29,6 +150,9 @@ func ParseRegion(host string) (string, error) {
 	}
 
 	match := regionPattern.FindStringSubmatch(host)
+	if match == nil {
+		match = vpcEndpointPattern.FindStringSubmatch(host)
+	}
 	if match == nil {
 		return "", fmt.Errorf("unable to parse region from hostname: '%s'", host)
 	}
@@ -36,9 +160,43 @@ func ParseRegion(host string) (string, error) {
 	return match[1], nil
 }
 
-// BuildHostname constructs a full DSQL hostname from a cluster ID and region.
+// BuildHostname constructs the standard DSQL hostname for a cluster ID and
+// region, using the DNS suffix of the region's partition. It returns an empty
+// string if no DSQL endpoints are known in that partition.
 func BuildHostname(clusterID, region string) string {
-	return clusterID + preRegionHostPattern + region + postRegionHostPattern
+	host, _ := BuildEndpoint(clusterID, region, EndpointStandard)
+	return host
+}
+
+// BuildEndpoint constructs the DSQL hostname of the given endpoint variant for a
+// cluster ID and region. It returns an error if the variant is unknown, or if
+// the region's partition has no known DSQL endpoints of the variant.
+func BuildEndpoint(clusterID, region string, variant EndpointVariant) (string, error) {
+	if !validEndpointVariant(variant) {
+		return "", fmt.Errorf("unknown endpoint variant %q", variant)
+	}
+	p := partitionForRegion(region)
+	if p.dnsSuffix == "" {
+		return "", noEndpointsError(p, region)
+	}
+	if (variant.fips() && !p.fips) || (variant.dualStack() && p.dualStackDNSSuffix == "") {
+		return "", fmt.Errorf("endpoint variant %q is not available in partition %q", variant, p.name)
+	}
+
+	service, suffix := signingName, p.dnsSuffix
+	if variant.fips() {
+		service += "-fips"
+	}
+	if variant.dualStack() {
+		suffix = p.dualStackDNSSuffix
+	}
+	return clusterID + "." + service + "." + region + "." + suffix, nil
+}
+
+// noEndpointsError reports that no DSQL endpoints are known in the partition
+// of region.
+func noEndpointsError(p *partition, region string) error {
+	return fmt.Errorf("no DSQL endpoints are known in partition %q of region %q", p.name, region)
 }
 
 // IsClusterID returns true if the host is a cluster ID rather than a full hostname.
@@ -48,3 +206,88 @@ func IsClusterID(host string) bool {
 	}
 	return clusterIDPattern.MatchString(host)
 }
+
+// validRegion reports whether region is a well-formed AWS region name.
+func validRegion(region string) bool {
+	return validRegionPattern.MatchString(region)
+}
+
+// IsVPCEndpoint returns true if host is the DNS name of an interface VPC
+// endpoint rather than a cluster endpoint.
+func IsVPCEndpoint(host string) bool {
+	return vpcEndpointPattern.MatchString(host)
+}
+
+// ClusterARN is the parsed Amazon Resource Name of a DSQL cluster, of the form
+// arn:partition:dsql:region:account-id:cluster/cluster-id.
+type ClusterARN struct {
+	Partition string
+	Region    string
+	AccountID string
+	ClusterID string
+}
+
+// String returns the ARN in its canonical form.
+func (a ClusterARN) String() string {
+	return "arn:" + a.Partition + ":dsql:" + a.Region + ":" + a.AccountID + ":cluster/" + a.ClusterID
+}
+
+// accountIDPattern validates AWS account IDs: 12 digits
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// IsClusterARN returns true if host has the form of an ARN rather than a
+// hostname or cluster ID. It does not check that the ARN is valid.
+func IsClusterARN(host string) bool {
+	return strings.HasPrefix(host, "arn:")
+}
+
+// ParseClusterARN parses the ARN of a DSQL cluster.
+func ParseClusterARN(arn string) (ClusterARN, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return ClusterARN{}, fmt.Errorf("invalid cluster ARN %q: expected arn:partition:dsql:region:account-id:cluster/cluster-id", arn)
+	}
+
+	parsed := ClusterARN{
+		Partition: parts[1],
+		Region:    parts[3],
+		AccountID: parts[4],
+	}
+	resource, clusterID, _ := strings.Cut(parts[5], "/")
+
+	switch {
+	case parsed.Partition == "":
+		return ClusterARN{}, fmt.Errorf("invalid cluster ARN %q: missing partition", arn)
+	case parts[2] != signingName:
+		return ClusterARN{}, fmt.Errorf("invalid cluster ARN %q: service is %q, not %q", arn, parts[2], signingName)
+	case !validRegion(parsed.Region):
+		return ClusterARN{}, fmt.Errorf("invalid cluster ARN %q: malformed region %q", arn, parsed.Region)
+	case !accountIDPattern.MatchString(parsed.AccountID):
+		return ClusterARN{}, fmt.Errorf("invalid cluster ARN %q: malformed account ID %q", arn, parsed.AccountID)
+	case resource != "cluster" || !clusterIDPattern.MatchString(clusterID):
+		return ClusterARN{}, fmt.Errorf("invalid cluster ARN %q: expected resource cluster/cluster-id", arn)
+	}
+	parsed.ClusterID = clusterID
+	return parsed, nil
+}
+
+// ParseClusterID returns the cluster ID identified by host, which may be a
+// cluster ID, a cluster ARN, or a cluster hostname.
+func ParseClusterID(host string) (string, error) {
+	switch {
+	case IsClusterID(host):
+		return host, nil
+	case IsClusterARN(host):
+		arn, err := ParseClusterARN(host)
+		if err != nil {
+			return "", err
+		}
+		return arn.ClusterID, nil
+	case regionPattern.MatchString(host):
+		clusterID, _, _ := strings.Cut(host, ".")
+		if IsClusterID(clusterID) {
+			return clusterID, nil
+		}
+	}
+	return "", fmt.Errorf("unable to parse cluster ID from '%s'", host)
+}
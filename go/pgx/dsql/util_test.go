@@ -44,6 +44,31 @@ func TestParseRegion(t *testing.T) {
 			host:     "mycluster.dsqlbeta.eu-west-1.on.aws",
 			expected: "eu-west-1",
 		},
+		{
+			name:     "FIPS endpoint",
+			host:     "mycluster.dsql-fips.us-east-1.on.aws",
+			expected: "us-east-1",
+		},
+		{
+			name:     "dual-stack endpoint",
+			host:     "mycluster.dsql.us-east-1.api.aws",
+			expected: "us-east-1",
+		},
+		{
+			name:     "FIPS dual-stack endpoint",
+			host:     "mycluster.dsql-fips.us-east-1.api.aws",
+			expected: "us-east-1",
+		},
+		{
+			name:     "GovCloud endpoint",
+			host:     "mycluster.dsql.us-gov-west-1.on.aws",
+			expected: "us-gov-west-1",
+		},
+		{
+			name:        "invalid hostname - unknown DNS suffix",
+			host:        "mycluster.dsql.cn-north-1.on.amazonwebservices.com.cn",
+			expectError: true,
+		},
 		{
 			name:        "invalid hostname - no dsql",
 			host:        "mycluster.rds.us-east-1.amazonaws.com",
@@ -59,6 +84,26 @@ func TestParseRegion(t *testing.T) {
 			host:        "mycluster",
 			expectError: true,
 		},
+		{
+			name:     "VPC endpoint",
+			host:     "vpce-0123456789abcdef0-abcdefgh.dsql-fnh4.us-east-1.vpce.amazonaws.com",
+			expected: "us-east-1",
+		},
+		{
+			name:     "zonal VPC endpoint",
+			host:     "vpce-0123456789abcdef0-abcdefgh-us-west-2a.dsql-fnh4.us-west-2.vpce.amazonaws.com",
+			expected: "us-west-2",
+		},
+		{
+			name:     "China VPC endpoint",
+			host:     "vpce-0123456789abcdef0-abcdefgh.dsql-fnh4.cn-north-1.vpce.amazonaws.com.cn",
+			expected: "cn-north-1",
+		},
+		{
+			name:        "private DNS name",
+			host:        "dsql.us-east-1.vpce.amazonaws.com",
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -103,6 +148,59 @@ func TestBuildHostname(t *testing.T) {
 	}
 }
 
+func TestBuildEndpoint(t *testing.T) {
+	tests := []struct {
+		region  string
+		variant EndpointVariant
+		want    string
+		wantErr string
+	}{
+		{"us-east-1", EndpointStandard, "mycluster.dsql.us-east-1.on.aws", ""},
+		{"us-east-1", EndpointFIPS, "mycluster.dsql-fips.us-east-1.on.aws", ""},
+		{"us-east-1", EndpointDualStack, "mycluster.dsql.us-east-1.api.aws", ""},
+		{"us-east-1", EndpointFIPSDualStack, "mycluster.dsql-fips.us-east-1.api.aws", ""},
+		{"us-gov-west-1", EndpointStandard, "mycluster.dsql.us-gov-west-1.on.aws", ""},
+		{"us-gov-west-1", EndpointFIPS, "", `endpoint variant "fips" is not available in partition "aws-us-gov"`},
+		{"us-gov-west-1", EndpointDualStack, "", `endpoint variant "dualstack" is not available in partition "aws-us-gov"`},
+		{"cn-north-1", EndpointStandard, "", `no DSQL endpoints are known in partition "aws-cn" of region "cn-north-1"`},
+		{"cn-north-1", EndpointFIPS, "", `no DSQL endpoints are known in partition "aws-cn"`},
+		{"us-east-1", "ipv6", "", `unknown endpoint variant "ipv6"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.region+"/"+string(tt.variant), func(t *testing.T) {
+			host, err := BuildEndpoint("mycluster", tt.region, tt.variant)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, host)
+
+			region, err := ParseRegion(host)
+			require.NoError(t, err)
+			assert.Equal(t, tt.region, region)
+		})
+	}
+}
+
+func TestPartitionForRegion(t *testing.T) {
+	assert.Equal(t, "aws", PartitionForRegion("us-east-1"))
+	assert.Equal(t, "aws-us-gov", PartitionForRegion("us-gov-east-1"))
+	assert.Equal(t, "aws-cn", PartitionForRegion("cn-northwest-1"))
+	assert.Equal(t, "aws", PartitionForRegion("xx-new-1"))
+}
+
+func TestValidRegion(t *testing.T) {
+	for _, region := range []string{"us-east-1", "ap-southeast-5", "us-gov-west-1", "eusc-de-east-1", "us-isob-east-1"} {
+		assert.True(t, validRegion(region), region)
+	}
+	for _, region := range []string{"", "US_EAST_1", "useast", "east-1", "us-east", "us--east-1", "-us-east-1", "us-east-1a"} {
+		assert.False(t, validRegion(region), region)
+	}
+}
+
 func TestIsClusterID(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -178,3 +276,70 @@ func TestIsClusterID(t *testing.T) {
 		})
 	}
 }
+
+func TestIsVPCEndpoint(t *testing.T) {
+	assert.True(t, IsVPCEndpoint("vpce-0123456789abcdef0-abcdefgh.dsql-fnh4.us-east-1.vpce.amazonaws.com"))
+	assert.True(t, IsVPCEndpoint("*.vpce-0123456789abcdef0-abcdefgh.dsql-fnh4.us-east-1.vpce.amazonaws.com"))
+	assert.False(t, IsVPCEndpoint("mycluster.dsql.us-east-1.on.aws"))
+	assert.False(t, IsVPCEndpoint("ijsamhssbh36dopuigphknejb4"))
+}
+
+func TestParseClusterARN(t *testing.T) {
+	const arn = "arn:aws:dsql:us-east-1:123456789012:cluster/ijsamhssbh36dopuigphknejb4"
+
+	parsed, err := ParseClusterARN(arn)
+	require.NoError(t, err)
+	assert.Equal(t, ClusterARN{
+		Partition: "aws",
+		Region:    "us-east-1",
+		AccountID: "123456789012",
+		ClusterID: "ijsamhssbh36dopuigphknejb4",
+	}, parsed)
+	assert.Equal(t, arn, parsed.String())
+
+	tests := []struct {
+		name    string
+		arn     string
+		wantErr string
+	}{
+		{"too few parts", "arn:aws:dsql:us-east-1:123456789012", "expected arn:partition:dsql"},
+		{"wrong service", "arn:aws:rds:us-east-1:123456789012:cluster/ijsamhssbh36dopuigphknejb4", `service is "rds"`},
+		{"bad region", "arn:aws:dsql:useast1:123456789012:cluster/ijsamhssbh36dopuigphknejb4", "malformed region"},
+		{"bad account", "arn:aws:dsql:us-east-1:1234:cluster/ijsamhssbh36dopuigphknejb4", "malformed account ID"},
+		{"bad resource", "arn:aws:dsql:us-east-1:123456789012:table/ijsamhssbh36dopuigphknejb4", "expected resource cluster/cluster-id"},
+		{"bad cluster ID", "arn:aws:dsql:us-east-1:123456789012:cluster/mycluster", "expected resource cluster/cluster-id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseClusterARN(tt.arn)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestParseClusterID(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{"ijsamhssbh36dopuigphknejb4", "ijsamhssbh36dopuigphknejb4", false},
+		{"arn:aws:dsql:us-east-1:123456789012:cluster/ijsamhssbh36dopuigphknejb4", "ijsamhssbh36dopuigphknejb4", false},
+		{"ijsamhssbh36dopuigphknejb4.dsql.us-east-1.on.aws", "ijsamhssbh36dopuigphknejb4", false},
+		{"mycluster.dsql.us-east-1.on.aws", "", true},
+		{"arn:aws:dsql:us-east-1:123456789012:cluster/mycluster", "", true},
+		{"localhost", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := ParseClusterID(tt.host)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}